@@ -0,0 +1,68 @@
+package path
+
+import (
+	"fmt"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// PathBuilder builds a Path one segment at a time, validating it once when it
+// is built rather than every time a segment is added. The zero value is an
+// empty builder, which needs a root before it can build a path.
+type PathBuilder struct {
+	namespace string
+	root      cid.Cid
+	segments  []string
+}
+
+// Root sets the root of the path to /<namespace>/<c>, keeping the segments
+// appended so far.
+func (b *PathBuilder) Root(namespace string, c cid.Cid) *PathBuilder {
+	b.namespace = namespace
+	b.root = c
+	return b
+}
+
+// Append adds seg to the end of the path.
+func (b *PathBuilder) Append(seg string) *PathBuilder {
+	b.segments = append(b.segments, seg)
+	return b
+}
+
+// Build returns the path built so far. An error is returned if no root was
+// set, if a segment is empty or contains a '/', or if the resulting path is
+// invalid. The builder can keep being used afterwards.
+func (b *PathBuilder) Build() (Path, error) {
+	size := len(b.namespace) + 2
+	if b.root.Defined() {
+		// string encodings of cids are at most twice as long as their bytes
+		size += b.root.ByteLen() * 2
+	}
+	for _, seg := range b.segments {
+		size += len(seg) + 1
+	}
+
+	var sb strings.Builder
+	sb.Grow(size)
+	sb.WriteString("/")
+	sb.WriteString(b.namespace)
+	sb.WriteString("/")
+	if b.root.Defined() {
+		sb.WriteString(b.root.String())
+	}
+	for _, seg := range b.segments {
+		sb.WriteString("/")
+		sb.WriteString(seg)
+	}
+
+	if !b.root.Defined() {
+		return "", &pathError{error: fmt.Errorf("no root"), path: sb.String()}
+	}
+	for i, seg := range b.segments {
+		if seg == "" || strings.Contains(seg, "/") {
+			return "", &pathError{error: fmt.Errorf("invalid segment %d: %q", i, seg), path: sb.String()}
+		}
+	}
+	return ParsePath(sb.String())
+}
@@ -0,0 +1,91 @@
+package path
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestPathBuilder(t *testing.T) {
+	c, err := cid.Decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b PathBuilder
+	segs := []string{c.String()}
+	b.Root("ipfs", c)
+	for i := 0; i < 100; i++ {
+		seg := fmt.Sprintf("dir-%d", i)
+		b.Append(seg)
+		segs = append(segs, seg)
+	}
+	built, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := FromSegments("/ipfs/", segs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built != expected {
+		t.Fatalf("expected %s, got %s", expected, built)
+	}
+
+	built, err = new(PathBuilder).Root("ipld", c).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built != "/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n" {
+		t.Fatalf("unexpected path %s", built)
+	}
+
+	for name, b := range map[string]*PathBuilder{
+		"no root":           new(PathBuilder).Append("a"),
+		"empty segment":     new(PathBuilder).Root("ipfs", c).Append("a").Append(""),
+		"slash in segment":  new(PathBuilder).Root("ipfs", c).Append("a/b"),
+		"unknown namespace": new(PathBuilder).Root("foo", c),
+	} {
+		if _, err := b.Build(); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("%s: expected ErrBadPath, got %v", name, err)
+		}
+	}
+}
+
+func BenchmarkPathBuilder(b *testing.B) {
+	c, err := cid.Decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	if err != nil {
+		b.Fatal(err)
+	}
+	segs := make([]string, 32)
+	for i := range segs {
+		segs[i] = fmt.Sprintf("dir-%d", i)
+	}
+	b.ReportAllocs()
+
+	b.Run("FromSegments", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := FromCid(c)
+			for _, seg := range segs {
+				var err error
+				if p, err = FromSegments("", p.String(), seg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("PathBuilder", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var pb PathBuilder
+			pb.Root("ipfs", c)
+			for _, seg := range segs {
+				pb.Append(seg)
+			}
+			if _, err := pb.Build(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,9 +1,22 @@
 package path
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrBadPath is matched (using errors.Is) by every error returned when
+// parsing an invalid path.
+var ErrBadPath = errors.New("invalid path")
+
+// ErrUnknownNamespace is matched (using errors.Is) by the errors returned when
+// parsing a path that does not begin with a known namespace.
+var ErrUnknownNamespace = errors.New("unknown namespace")
+
+// ErrSegmentTooLong is returned by ParsePathWithLimits when a segment of the
+// path is longer than allowed.
+var ErrSegmentTooLong = errors.New("path segment too long")
+
 // helper type so path parsing errors include the path
 type pathError struct {
 	error error
@@ -21,3 +34,7 @@ func (e *pathError) Unwrap() error {
 func (e *pathError) Path() string {
 	return e.path
 }
+
+func (e *pathError) Is(target error) bool {
+	return target == ErrBadPath
+}
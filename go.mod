@@ -3,12 +3,18 @@ module github.com/ipfs/go-path
 go 1.16
 
 require (
+	github.com/hashicorp/golang-lru v0.5.4
 	github.com/ipfs/go-block-format v0.0.3
+	github.com/ipfs/go-blockservice v0.2.1
 	github.com/ipfs/go-cid v0.1.0
+	github.com/ipfs/go-datastore v0.5.0
 	github.com/ipfs/go-fetcher v1.6.1
+	github.com/ipfs/go-ipfs-blockstore v0.2.1
+	github.com/ipfs/go-ipfs-exchange-offline v0.1.1
 	github.com/ipfs/go-ipld-format v0.2.0
 	github.com/ipfs/go-log v1.0.5
 	github.com/ipfs/go-merkledag v0.5.1
+	github.com/ipfs/go-unixfs v0.2.4
 	github.com/ipfs/go-unixfsnode v1.1.2
 	github.com/ipld/go-codec-dagpb v1.3.0
 	github.com/ipld/go-ipld-prime v0.11.0
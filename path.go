@@ -2,8 +2,13 @@
 package path
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"path"
+	"sort"
 	"strings"
 
 	cid "github.com/ipfs/go-cid"
@@ -31,7 +36,8 @@ func FromCid(c cid.Cid) Path {
 }
 
 // Segments returns the different elements of a path
-// (elements are delimited by a /).
+// (elements are delimited by a /). The returned slice is freshly allocated on
+// every call and may be modified by the caller.
 func (p Path) Segments() []string {
 	cleaned := path.Clean(string(p))
 	segments := strings.Split(cleaned, "/")
@@ -44,16 +50,323 @@ func (p Path) Segments() []string {
 	return segments
 }
 
-// String converts a path to string.
+// rootedSegments returns the segments of the path, including the namespace
+// for paths of the form <key>.
+func (p Path) rootedSegments() []string {
+	segments := p.Segments()
+	if !strings.HasPrefix(string(p), "/") {
+		segments = append([]string{"ipfs"}, segments...)
+	}
+	return segments
+}
+
+// relativeSegments returns the segments of p if it is a relative path such as
+// a/b, which has no root: neither a namespace nor a leading key.
+func (p Path) relativeSegments() ([]string, bool) {
+	if p == "" || strings.HasPrefix(string(p), "/") {
+		return nil, false
+	}
+	segments := p.Segments()
+	if _, err := decodeCid(segments[0]); err == nil {
+		return nil, false
+	}
+	if len(segments) == 1 && segments[0] == "." {
+		return nil, true
+	}
+	return segments, true
+}
+
+// comparedSegments returns the segments of p as compared by Equal: its rooted
+// segments, or for a relative path, its segments following a "." segment,
+// which no rooted path begins with, so that a/b is told apart from /ipfs/a/b.
+func (p Path) comparedSegments() []string {
+	if segments, ok := p.relativeSegments(); ok {
+		return append([]string{"."}, segments...)
+	}
+	return p.rootedSegments()
+}
+
+// Depth returns the number of segments following the root of the path (the
+// /ipfs/<cid> or /ipns/<name> part), which is 0 for a path that is just a
+// key. Every segment of a relative path such as a/b is counted.
+func (p Path) Depth() int {
+	if segments, ok := p.relativeSegments(); ok {
+		return len(segments)
+	}
+	if n := len(p.rootedSegments()) - 2; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// String converts a path to string. As a Path is a string, this neither
+// allocates nor copies.
 func (p Path) String() string {
 	return string(p)
 }
 
+// Equal reports whether p and other refer to the same namespace, root and
+// segments, ignoring redundant slashes (such as a trailing slash). A path of
+// the form <key> is in the /ipfs/ namespace, but a relative path such as a/b
+// is never equal to a rooted one. The root is compared as a string: a CIDv0
+// and a CIDv1 of the same multihash are not considered equal.
+func (p Path) Equal(other Path) bool {
+	a, b := p.comparedSegments(), other.comparedSegments()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0 or 1 depending on whether a sorts before, with or
+// after b, for sorting paths deterministically. Paths are ordered by
+// namespace, then root, then segment by segment, with a path sorting before
+// the paths it is a prefix of. Relative paths sort before rooted ones.
+// Compare(a, b) is 0 exactly when a.Equal(b).
+func Compare(a, b Path) int {
+	as, bs := a.comparedSegments(), b.comparedSegments()
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Matches reports whether the segments of p match those of pattern, such as
+// /ipfs/*/blog/*/index.html, one by one, with the syntax of path.Match. The
+// path and the pattern must have as many segments, so a '*' matches exactly
+// one segment. A path of the form <key> is in the /ipfs/ namespace. An error
+// is only returned for a malformed pattern.
+func (p Path) Matches(pattern string) (bool, error) {
+	patterns := Path(pattern).Segments()
+	for _, pat := range patterns {
+		if _, err := path.Match(pat, ""); err != nil {
+			return false, err
+		}
+	}
+
+	segments := p.rootedSegments()
+	if len(segments) != len(patterns) {
+		return false, nil
+	}
+	for i, seg := range segments {
+		if ok, _ := path.Match(patterns[i], seg); !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CommonPrefix returns the longest path both a and b begin with, such as
+// /ipfs/<cid>/a for /ipfs/<cid>/a/b and /ipfs/<cid>/a/c. An error is returned
+// if either path is invalid, or if they do not have the same namespace and
+// root, as they then share no prefix.
+func CommonPrefix(a, b Path) (Path, error) {
+	aNamespace, aRoot, aRest, err := a.SplitRoot()
+	if err != nil {
+		return "", err
+	}
+	bNamespace, bRoot, bRest, err := b.SplitRoot()
+	if err != nil {
+		return "", err
+	}
+	if aNamespace != bNamespace || aRoot != bRoot {
+		return "", fmt.Errorf("paths %s and %s have different roots", a, b)
+	}
+
+	n := 0
+	for n < len(aRest) && n < len(bRest) && aRest[n] == bRest[n] {
+		n++
+	}
+	return FromSegments("/"+aNamespace+"/", append([]string{aRoot}, aRest[:n]...)...)
+}
+
+// TrimPrefix returns the segments of p following base, when base is a prefix
+// of p. Paths are compared segment by segment, as with Equal, so that
+// /ipfs/<cid>/site is not a prefix of /ipfs/<cid>/sites. ok is false when
+// base is not a prefix of p; rest is empty when both paths are equal.
+func (p Path) TrimPrefix(base Path) (rest []string, ok bool) {
+	segs, baseSegs := p.rootedSegments(), base.rootedSegments()
+	if len(baseSegs) > len(segs) {
+		return nil, false
+	}
+	for i := range baseSegs {
+		if segs[i] != baseSegs[i] {
+			return nil, false
+		}
+	}
+	return segs[len(baseSegs):], true
+}
+
+// TrimSuffix returns p without its final segments when they are segs, such as
+// to strip an index.html segment. Only the segments following the root can be
+// removed. ok is false, and p returned unchanged, when p does not end with
+// segs; the trailing slash of p, if any, is dropped otherwise. A relative path
+// such as a/b stays relative, as with Parent: every segment of it can be
+// removed, leaving ".".
+func (p Path) TrimSuffix(segs ...string) (trimmed Path, ok bool) {
+	if len(segs) == 0 {
+		return p, true
+	}
+	rel, relative := p.relativeSegments()
+	all, rootLen := rel, 0
+	if !relative {
+		all, rootLen = p.rootedSegments(), 2
+	}
+	n := len(all) - len(segs)
+	if n < rootLen {
+		return p, false
+	}
+	for i, seg := range segs {
+		if all[n+i] != seg {
+			return p, false
+		}
+	}
+	if !relative {
+		return Path("/" + strings.Join(all[:n], "/")), true
+	}
+	if n == 0 {
+		return ".", true
+	}
+	return Path(strings.Join(all[:n], "/")), true
+}
+
+// HasPrefix reports whether prefix is a prefix of p, comparing segments as
+// TrimPrefix does. A path is a prefix of itself.
+func (p Path) HasPrefix(prefix Path) bool {
+	_, ok := p.TrimPrefix(prefix)
+	return ok
+}
+
+// MarshalJSON implements json.Marshaler. A path is encoded as a JSON string.
+func (p Path) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(p))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The JSON string is parsed with
+// ParsePath, so invalid paths are rejected. As is the convention, a JSON null
+// leaves p unchanged.
+func (p *Path) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParsePath(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Path) MarshalText() ([]byte, error) {
+	return []byte(p), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. The text is parsed with
+// ParsePath, so invalid paths are rejected.
+func (p *Path) UnmarshalText(b []byte) error {
+	parsed, err := ParsePath(string(b))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Namespace returns the namespace of the path: "ipfs", "ipns" or "ipld". An
+// error is returned when the path does not begin with one of those namespaces,
+// which includes paths of the form <key> (use ParsePath to add the /ipfs/
+// prefix to those).
+func (p Path) Namespace() (string, error) {
+	parts := strings.SplitN(string(p), "/", 3)
+	if len(parts) < 2 || parts[0] != "" {
+		return "", &pathError{error: fmt.Errorf("path does not begin with '/'"), path: string(p)}
+	}
+
+	switch parts[1] {
+	case "ipfs", "ipns", "ipld":
+		return parts[1], nil
+	default:
+		return "", &pathError{error: fmt.Errorf("unknown namespace %q", parts[1]), path: string(p)}
+	}
+}
+
+// IsAbsolute reports whether the path begins with a recognized namespace
+// (/ipfs/, /ipns/ or /ipld/).
+func (p Path) IsAbsolute() bool {
+	_, err := p.Namespace()
+	return err == nil
+}
+
+// IsRelative reports whether the path is not absolute, such as a subpath
+// of the form a/b/c meant to be joined onto a base path.
+func (p Path) IsRelative() bool {
+	return !p.IsAbsolute()
+}
+
+// HasTrailingSlash reports whether the path ends with a '/', as in
+// /ipfs/<cid>/dir/, which by web conventions names a directory. Parsing keeps
+// the trailing slash, unlike normalizing.
+func (p Path) HasTrailingSlash() bool {
+	return len(p) > 1 && p[len(p)-1] == '/'
+}
+
 // IsJustAKey returns true if the path is of the form <key> or /ipfs/<key>, or
-// /ipld/<key>
+// /ipld/<key>, or /ipns/<name>. As for the other namespaces, an /ipns/ path is
+// just a key when it names no more than its root, whether the name is a key or
+// a DNSLink domain such as example.com: it is not resolved to find out.
 func (p Path) IsJustAKey() bool {
-	parts := p.Segments()
-	return len(parts) == 2 && (parts[0] == "ipfs" || parts[0] == "ipld")
+	// count the segments in place rather than splitting the path, as this
+	// is called on every resolution
+	s := string(p)
+	n := 0
+	first := ""
+	for len(s) > 0 {
+		seg := s
+		if i := strings.IndexByte(s, '/'); i >= 0 {
+			seg, s = s[:i], s[i+1:]
+		} else {
+			s = ""
+		}
+
+		switch seg {
+		case "", ".":
+			continue
+		case "..":
+			// rare enough to not be worth handling here
+			parts := p.Segments()
+			return len(parts) == 2 && isNamespace(parts[0])
+		}
+		if n == 0 {
+			first = seg
+		}
+		n++
+	}
+	return n == 2 && isNamespace(first)
+}
+
+// isNamespace reports whether ns is the namespace of a path: ipfs, ipld or
+// ipns.
+func isNamespace(ns string) bool {
+	return ns == "ipfs" || ns == "ipld" || ns == "ipns"
 }
 
 // PopLastSegment returns a new Path without its final segment, and the final
@@ -74,8 +387,137 @@ func (p Path) PopLastSegment() (Path, string, error) {
 	return newPath, segs[len(segs)-1], nil
 }
 
-// FromSegments returns a path given its different segments.
+// Normalize returns a new Path with "." and ".." segments resolved against
+// the part of the path following the /ipfs/<cid> or /ipns/<name> root. A "."
+// segment is dropped and a ".." segment removes the segment before it. The
+// root itself is never rewritten: an error is returned if a ".." segment
+// would climb above it.
+func (p Path) Normalize() (Path, error) {
+	parts := strings.Split(string(p), "/")
+
+	rootLen := rootLength(parts)
+	if len(parts) < rootLen {
+		return "", &pathError{error: fmt.Errorf("not enough path components"), path: string(p)}
+	}
+
+	out := append([]string{}, parts[:rootLen]...)
+	for _, seg := range parts[rootLen:] {
+		switch seg {
+		case ".":
+		case "..":
+			if len(out) == rootLen {
+				return "", &pathError{error: fmt.Errorf("\"..\" escapes the path root"), path: string(p)}
+			}
+			out = out[:len(out)-1]
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	return ParsePath(strings.Join(out, "/"))
+}
+
+// HashKey returns a fixed-length identifier of p, suitable as a map or cache
+// key: the hex-encoded SHA-256 of the segments of p as compared by Equal, so
+// that paths only written differently, such as a/./b/ and a/b, share the same
+// key, and equal paths always do. As with Equal, a relative path such as a/b
+// does not share the key of /ipfs/a/b.
+func (p Path) HashKey() string {
+	sum := sha256.Sum256([]byte("/" + strings.Join(p.comparedSegments(), "/")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Clean returns the shortest form of p, like path.Clean does for file paths,
+// but without ever rewriting the /ipfs/<cid> or /ipns/<name> root: redundant
+// slashes are removed and "." and ".." segments are resolved against the rest
+// of the path. Unlike Normalize, Clean never fails: a ".." segment climbing
+// above the root is dropped, and the path is not validated.
+func Clean(p Path) Path {
+	rooted := strings.HasPrefix(string(p), "/")
+	rootLen := 1
+	if rooted {
+		rootLen = 2
+	}
+
+	var out []string
+	for _, seg := range strings.Split(string(p), "/") {
+		switch {
+		case seg == "":
+		case len(out) < rootLen:
+			out = append(out, seg)
+		case seg == ".":
+		case seg == "..":
+			if len(out) > rootLen {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	if rooted {
+		return Path("/" + strings.Join(out, "/"))
+	}
+	return Path(strings.Join(out, "/"))
+}
+
+// Parent returns the path without its final segment. When there is nothing
+// to remove (the path is just a key, or an /ipns/ name without subpath), the
+// path is returned unchanged. The parent of a relative path such as a/b stays
+// relative: it is a, and the parent of a is ".".
+func (p Path) Parent() Path {
+	if segs, ok := p.relativeSegments(); ok {
+		if len(segs) <= 1 {
+			return "."
+		}
+		return Path(strings.Join(segs[:len(segs)-1], "/"))
+	}
+
+	segs := p.rootedSegments()
+	if len(segs) <= 2 {
+		return p
+	}
+	return Path("/" + strings.Join(segs[:len(segs)-1], "/"))
+}
+
+// Base returns the final segment of the path, or its root component (such as
+// the CID) when the path is just a key. Trailing slashes are ignored.
+func (p Path) Base() string {
+	segs := p.Segments()
+	return segs[len(segs)-1]
+}
+
+// WithSegment returns a new Path with seg appended to p. An error is returned
+// if seg is empty or contains a '/'.
+func (p Path) WithSegment(seg string) (Path, error) {
+	if seg == "" || strings.Contains(seg, "/") {
+		return "", &pathError{error: fmt.Errorf("invalid segment %q", seg), path: string(p)}
+	}
+	return ParsePath(strings.TrimSuffix(string(p), "/") + "/" + seg)
+}
+
+// ResolveRelative resolves the relative reference rel, which may contain "."
+// and ".." segments, against base, returning a new absolute path. base is
+// treated as a directory: to resolve a link found in a file, as with a relative
+// link in an HTML page, pass the parent of the file as base. A rel starting
+// with a '/' is an absolute path, and is returned as parsed by ParsePath. An
+// error is returned if rel climbs above the root of base.
+func ResolveRelative(base Path, rel string) (Path, error) {
+	if strings.HasPrefix(rel, "/") {
+		return ParsePath(rel)
+	}
+	return Path(strings.TrimSuffix(string(base), "/") + "/" + rel).Normalize()
+}
+
+// FromSegments returns a path given its different segments. The first
+// segment is the root of the path; an error is returned if any of the
+// following segments is empty.
 func FromSegments(prefix string, seg ...string) (Path, error) {
+	for i := 1; i < len(seg); i++ {
+		if seg[i] == "" {
+			return "", &pathError{error: fmt.Errorf("segment %d is empty", i), path: prefix + strings.Join(seg, "/")}
+		}
+	}
 	return ParsePath(prefix + strings.Join(seg, "/"))
 }
 
@@ -83,8 +525,22 @@ func FromSegments(prefix string, seg ...string) (Path, error) {
 // The returned path will always be prefixed with /ipfs/ or /ipns/.
 // The prefix will be added if not present in the given string.
 // This function will return an error when the given string is
-// not a valid ipfs path.
+// not a valid ipfs path, which includes paths holding control characters
+// (bytes below 0x20, and 0x7f) such as NUL bytes and newlines; use
+// ParsePathLenient to accept those.
 func ParsePath(txt string) (Path, error) {
+	for i := 0; i < len(txt); i++ {
+		if txt[i] < 0x20 || txt[i] == 0x7f {
+			return "", &pathError{error: fmt.Errorf("control character %q at offset %d", txt[i], i), path: txt}
+		}
+	}
+	return ParsePathLenient(txt)
+}
+
+// ParsePathLenient is like ParsePath, but accepts paths holding control
+// characters, for compatibility with paths created before ParsePath rejected
+// them.
+func ParsePathLenient(txt string) (Path, error) {
 	parts := strings.Split(txt, "/")
 	if len(parts) == 1 {
 		kp, err := ParseCidToPath(txt)
@@ -123,12 +579,109 @@ func ParsePath(txt string) (Path, error) {
 			return "", &pathError{error: fmt.Errorf("not enough path components"), path: txt}
 		}
 	default:
-		return "", &pathError{error: fmt.Errorf("unknown namespace %q", parts[1]), path: txt}
+		return "", &pathError{error: fmt.Errorf("%w %q", ErrUnknownNamespace, parts[1]), path: txt}
 	}
 
 	return Path(txt), nil
 }
 
+// ParsePathStrict is like ParsePath, but only accepts paths beginning with
+// their namespace (/ipfs/, /ipns/ or /ipld/): rather than being taken as
+// /ipfs/ paths, paths of the form <key> are rejected, with an error matching
+// ErrUnknownNamespace as for paths in unknown namespaces.
+func ParsePathStrict(txt string) (Path, error) {
+	if !strings.HasPrefix(txt, "/") {
+		return "", &pathError{error: fmt.Errorf("%w: path does not begin with a namespace", ErrUnknownNamespace), path: txt}
+	}
+	return ParsePath(txt)
+}
+
+// ParsePathDecoded is like ParsePath, but percent-decodes every segment
+// following the root (as found in URL-encoded gateway paths). The root
+// component is kept as is. The decoded segments are escaped as understood by
+// resolvers created WithEscapedSegments: an encoded slash (%2F) does not
+// delimit segments, but is kept within its segment as "\/", and every
+// backslash is written "\\".
+func ParsePathDecoded(txt string) (Path, error) {
+	parts := strings.Split(txt, "/")
+	for i := rootLength(parts); i < len(parts); i++ {
+		seg, err := url.PathUnescape(parts[i])
+		if err != nil {
+			return "", &pathError{error: err, path: txt}
+		}
+		parts[i] = strings.NewReplacer("\\", "\\\\", "/", "\\/").Replace(seg)
+	}
+
+	return ParsePath(strings.Join(parts, "/"))
+}
+
+// ParsePathWithLimits is like ParsePath, but rejects paths holding a segment
+// following the root that is longer than maxSegLen bytes with an error
+// matching ErrSegmentTooLong. A maxSegLen of zero means no limit.
+func ParsePathWithLimits(txt string, maxSegLen int) (Path, error) {
+	p, err := ParsePath(txt)
+	if err != nil || maxSegLen <= 0 {
+		return p, err
+	}
+
+	parts := strings.Split(string(p), "/")
+	for _, seg := range parts[rootLength(parts):] {
+		if len(seg) > maxSegLen {
+			return "", &pathError{error: fmt.Errorf("%w: %d bytes, at most %d allowed", ErrSegmentTooLong, len(seg), maxSegLen), path: txt}
+		}
+	}
+	return p, nil
+}
+
+// EscapeForURL returns the path with every segment following the root
+// percent-encoded as in a URL path, so that it can be embedded in an HTTP
+// gateway URL: it is the reverse of ParsePathDecoded. The escapes written by
+// ParsePathDecoded are read back first: "\/" is part of its segment, and is
+// encoded as %2F, and "\\" as %5C. Other backslashes are encoded as they are.
+// The namespace, the root and the '/' delimiters are kept as is.
+func (p Path) EscapeForURL() string {
+	parts := strings.Split(string(p), "/")
+	root := rootLength(parts)
+	escaped := parts[:root:root]
+	var seg strings.Builder
+	for i := root; i < len(parts); i++ {
+		seg.WriteString(parts[i])
+		// an odd number of trailing backslashes escapes the delimiter
+		n := len(parts[i]) - len(strings.TrimRight(parts[i], "\\"))
+		if n%2 == 1 && i < len(parts)-1 {
+			seg.WriteByte('/')
+			continue
+		}
+		unescaped := strings.NewReplacer("\\\\", "\\", "\\/", "/").Replace(seg.String())
+		escaped = append(escaped, url.PathEscape(unescaped))
+		seg.Reset()
+	}
+	return strings.Join(escaped, "/")
+}
+
+// ToURL returns the URL of p on the HTTP gateway at the base URL gateway, such
+// as https://ipfs.io, with the path escaped as by EscapeForURL. p must be a
+// valid path, and gateway an absolute http or https URL without a query or a
+// fragment; it may have a path of its own, to which p is appended.
+func (p Path) ToURL(gateway string) (string, error) {
+	parsed, err := ParsePath(string(p))
+	if err != nil {
+		return "", err
+	}
+
+	base, err := url.Parse(gateway)
+	if err != nil {
+		return "", fmt.Errorf("invalid gateway URL: %w", err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return "", fmt.Errorf("invalid gateway URL %q: not an absolute http or https URL", gateway)
+	}
+	if base.RawQuery != "" || base.Fragment != "" || base.ForceQuery {
+		return "", fmt.Errorf("invalid gateway URL %q: has a query or a fragment", gateway)
+	}
+	return strings.TrimSuffix(base.String(), "/") + parsed.EscapeForURL(), nil
+}
+
 // ParseCidToPath takes a CID in string form and returns a valid ipfs Path.
 func ParseCidToPath(txt string) (Path, error) {
 	if txt == "" {
@@ -143,42 +696,307 @@ func ParseCidToPath(txt string) (Path, error) {
 	return FromCid(c), nil
 }
 
+// ParseDNSLinkPath returns the /ipns/ path of host, a DNSLink domain name
+// optionally followed by a path within it, as written in a gateway URL without
+// its scheme: en.wikipedia-on-ipfs.org/wiki/ is returned as
+// /ipns/en.wikipedia-on-ipfs.org/wiki/. The domain name must be made of at
+// least two labels of letters, digits and hyphens.
+func ParseDNSLinkPath(host string) (Path, error) {
+	name := host
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		name = host[:i]
+	}
+	if !isDomainName(name) {
+		return "", &pathError{error: fmt.Errorf("invalid DNSLink domain name %q", name), path: host}
+	}
+	return ParsePath("/ipns/" + host)
+}
+
+// isDomainName reports whether name is a fully qualified host name, without
+// the trailing dot.
+func isDomainName(name string) bool {
+	labels := strings.Split(name, ".")
+	if len(name) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // IsValid checks if a path is a valid ipfs Path.
 func (p *Path) IsValid() error {
 	_, err := ParsePath(p.String())
 	return err
 }
 
+// PathIssueKind is the kind of a problem found in a path by Validate.
+type PathIssueKind int
+
+const (
+	// IssueControlCharacter is a control character: a byte below 0x20, or
+	// 0x7f.
+	IssueControlCharacter PathIssueKind = iota
+	// IssueBadNamespace is a path beginning with '/' that is not followed by
+	// a known namespace, or a namespace that is not preceded by a '/'.
+	IssueBadNamespace
+	// IssueMissingRoot is a path without a root following its namespace.
+	IssueMissingRoot
+	// IssueInvalidRoot is an /ipfs/ or /ipld/ path whose root is not a CID.
+	IssueInvalidRoot
+	// IssueEmptySegment is an empty segment following the root, other than
+	// the one left by a trailing slash.
+	IssueEmptySegment
+)
+
+func (k PathIssueKind) String() string {
+	switch k {
+	case IssueControlCharacter:
+		return "control character"
+	case IssueBadNamespace:
+		return "bad namespace"
+	case IssueMissingRoot:
+		return "missing root"
+	case IssueInvalidRoot:
+		return "invalid root"
+	case IssueEmptySegment:
+		return "empty segment"
+	default:
+		return fmt.Sprintf("PathIssueKind(%d)", int(k))
+	}
+}
+
+// PathIssue is a problem found in a path by Validate.
+type PathIssue struct {
+	Kind PathIssueKind
+	// Offset is the offset in bytes of the problem within the path.
+	Offset  int
+	Message string
+}
+
+// Validate returns every problem found in p, in the order they appear in it,
+// or nothing if p is valid. Rather than stopping at the first problem as
+// ParsePath does, every segment is checked, so that all the problems can be
+// shown at once. Unlike ParsePath, Validate also reports empty segments.
+func (p Path) Validate() []PathIssue {
+	txt := string(p)
+	var issues []PathIssue
+	for i := 0; i < len(txt); i++ {
+		if txt[i] < 0x20 || txt[i] == 0x7f {
+			issues = append(issues, PathIssue{Kind: IssueControlCharacter, Offset: i, Message: fmt.Sprintf("control character %q", txt[i])})
+		}
+	}
+
+	parts := strings.Split(txt, "/")
+	offsets := make([]int, len(parts))
+	for i := 1; i < len(parts); i++ {
+		offsets[i] = offsets[i-1] + len(parts[i-1]) + 1
+	}
+
+	root := 0
+	switch {
+	case txt == "":
+		issues = append(issues, PathIssue{Kind: IssueMissingRoot, Message: "empty path"})
+	case parts[0] != "":
+		// like ParsePath, take <key> to be /ipfs/<key>
+		if isNamespace(parts[0]) {
+			issues = append(issues, PathIssue{Kind: IssueBadNamespace, Message: "path does not begin with '/'"})
+		} else if _, err := decodeCid(parts[0]); err != nil {
+			issues = append(issues, PathIssue{Kind: IssueInvalidRoot, Message: fmt.Sprintf("invalid CID: %s", err)})
+		}
+	default:
+		root = 2
+		ns := parts[1]
+		if !isNamespace(ns) {
+			issues = append(issues, PathIssue{Kind: IssueBadNamespace, Offset: offsets[1], Message: fmt.Sprintf("%s %q", ErrUnknownNamespace, ns)})
+		}
+		if len(parts) < 3 || parts[2] == "" {
+			offset := len(txt)
+			if len(parts) >= 3 {
+				offset = offsets[2]
+			}
+			issues = append(issues, PathIssue{Kind: IssueMissingRoot, Offset: offset, Message: "not enough path components"})
+		} else if ns == "ipfs" || ns == "ipld" {
+			if _, err := decodeCid(parts[2]); err != nil {
+				issues = append(issues, PathIssue{Kind: IssueInvalidRoot, Offset: offsets[2], Message: fmt.Sprintf("invalid CID: %s", err)})
+			}
+		}
+	}
+
+	for i := root + 1; i < len(parts)-1; i++ {
+		if parts[i] == "" {
+			issues = append(issues, PathIssue{Kind: IssueEmptySegment, Offset: offsets[i], Message: fmt.Sprintf("segment %d is empty", i-root-1)})
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Offset < issues[j].Offset
+	})
+	return issues
+}
+
 // Join joins strings slices using /
 func Join(pths []string) string {
 	return strings.Join(pths, "/")
 }
 
+// JoinStrict joins strings slices using /, like Join, but returns an error
+// if any of the segments is empty or contains a '/', as either would change
+// the number of components of the resulting path.
+func JoinStrict(segs []string) (string, error) {
+	for i, seg := range segs {
+		if seg == "" {
+			return "", &pathError{error: fmt.Errorf("segment %d is empty", i), path: Join(segs)}
+		}
+		if strings.Contains(seg, "/") {
+			return "", &pathError{error: fmt.Errorf("segment %q contains a '/'", seg), path: Join(segs)}
+		}
+	}
+	return Join(segs), nil
+}
+
 // SplitList splits strings usings /
 func SplitList(pth string) []string {
 	return strings.Split(pth, "/")
 }
 
+// SplitRoot validates the path and splits it into its namespace ("ipfs",
+// "ipns" or "ipld"), its root (a CID or, for /ipns/ paths, a name such as a
+// DNSLink domain) and the segments following the root. A path of the form
+// <key> is in the "ipfs" namespace.
+func (p Path) SplitRoot() (namespace string, root string, rest []string, err error) {
+	parsed, err := ParsePath(string(p))
+	if err != nil {
+		return "", "", nil, err
+	}
+	namespace, err = parsed.Namespace()
+	if err != nil {
+		return "", "", nil, err
+	}
+	root, rest, err = SplitAbsPathName(parsed)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return namespace, root, rest, nil
+}
+
+// SplitAt validates the path and splits it before its i-th segment following
+// the root: head is the rooted path made of the root and the first i segments,
+// and tail holds the remaining segments. i ranges from 0, for head to be just
+// the root, to the depth of the path (see Depth), for tail to be empty; an
+// error is returned for any other index.
+func (p Path) SplitAt(i int) (head Path, tail []string, err error) {
+	namespace, root, rest, err := p.SplitRoot()
+	if err != nil {
+		return "", nil, err
+	}
+	if i < 0 || i > len(rest) {
+		return "", nil, &pathError{error: fmt.Errorf("segment index %d out of range [0, %d]", i, len(rest)), path: string(p)}
+	}
+	head, err = FromSegments("/"+namespace+"/", append([]string{root}, rest[:i]...)...)
+	if err != nil {
+		return "", nil, err
+	}
+	return head, rest[i:], nil
+}
+
+// ReplaceRoot returns p with its namespace and root replaced by the given
+// ones, keeping the segments following the root, such as to rebase
+// /ipns/<name>/a/b onto the /ipfs/<cid> the name resolves to. An error is
+// returned if p is invalid or the new root is invalid for the namespace.
+func (p Path) ReplaceRoot(namespace, root string) (Path, error) {
+	_, _, rest, err := p.SplitRoot()
+	if err != nil {
+		return "", err
+	}
+	txt := "/" + namespace + "/" + root
+	if len(rest) > 0 {
+		txt += "/" + Join(rest)
+	}
+	if p.HasTrailingSlash() {
+		txt += "/"
+	}
+	return ParsePath(txt)
+}
+
+// RootCid returns the CID the path is rooted at, for /ipfs/ and /ipld/ paths
+// and paths of the form <key>. Its codec, as found in Prefix().Codec, is known
+// without resolving the path. An error is returned for /ipns/ paths, which are
+// rooted at a mutable name rather than at content, even when that name is a
+// CID.
+func (p Path) RootCid() (cid.Cid, error) {
+	if ns, err := p.Namespace(); err == nil && ns == "ipns" {
+		return cid.Cid{}, &pathError{error: fmt.Errorf("/ipns/ paths are not rooted at a CID"), path: string(p)}
+	}
+	c, _, err := SplitAbsPath(p)
+	return c, err
+}
+
+// Codec returns the multicodec of the CID the path is rooted at, as described
+// in RootCid.
+func (p Path) Codec() (uint64, error) {
+	c, err := p.RootCid()
+	if err != nil {
+		return 0, err
+	}
+	return c.Prefix().Codec, nil
+}
+
 // SplitAbsPath clean up and split fpath. It extracts the first component (which
-// must be a Multihash) and return it separately.
+// must be a Multihash) and return it separately. /ipns/ paths are rejected,
+// even when their name is a CID (such as a libp2p key), as that name does not
+// address immutable content; use SplitAbsPathName to split them.
 func SplitAbsPath(fpath Path) (cid.Cid, []string, error) {
+	if ns, _ := fpath.Namespace(); ns == "ipns" {
+		return cid.Cid{}, nil, &pathError{error: fmt.Errorf("/ipns/ names must be resolved to an immutable path first"), path: string(fpath)}
+	}
+	name, rest, err := SplitAbsPathName(fpath)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	c, err := decodeCid(name)
+	// first element in the path is a cid
+	if err != nil {
+		return cid.Cid{}, nil, &pathError{error: fmt.Errorf("invalid CID: %s", err), path: string(fpath)}
+	}
+
+	return c, rest, nil
+}
+
+// SplitAbsPathName clean up and split fpath. It extracts the first component
+// following the namespace and returns it separately, without requiring it to be
+// a CID: for /ipns/ paths it may be any name, such as a DNSLink domain.
+func SplitAbsPathName(fpath Path) (string, []string, error) {
 	parts := fpath.Segments()
-	if parts[0] == "ipfs" || parts[0] == "ipld" {
+	if isNamespace(parts[0]) {
 		parts = parts[1:]
 	}
 
 	// if nothing, bail.
 	if len(parts) == 0 {
-		return cid.Cid{}, nil, &pathError{error: fmt.Errorf("empty"), path: string(fpath)}
+		return "", nil, &pathError{error: fmt.Errorf("empty"), path: string(fpath)}
 	}
 
-	c, err := decodeCid(parts[0])
-	// first element in the path is a cid
-	if err != nil {
-		return cid.Cid{}, nil, &pathError{error: fmt.Errorf("invalid CID: %s", err), path: string(fpath)}
-	}
+	return parts[0], parts[1:], nil
+}
 
-	return c, parts[1:], nil
+// rootLength returns the number of leading parts of a split path that make
+// up its root: <key> or /<namespace>/<key>.
+func rootLength(parts []string) int {
+	if parts[0] == "" {
+		return 3
+	}
+	return 1
 }
 
 func decodeCid(cstr string) (cid.Cid, error) {
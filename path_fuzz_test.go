@@ -0,0 +1,30 @@
+//go:build go1.18
+// +build go1.18
+
+package path
+
+import "testing"
+
+func FuzzParsePath(f *testing.F) {
+	for _, seed := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipns/example.com/a",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, txt string) {
+		p, err := ParsePath(txt)
+		if err != nil {
+			return
+		}
+		again, err := ParsePath(p.String())
+		if err != nil {
+			t.Fatalf("ParsePath(%q) returned %q, which does not parse: %s", txt, p, err)
+		}
+		if again != p {
+			t.Fatalf("ParsePath(%q) returned %q, which parses to %q", txt, p, again)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+//go:build go1.23
+// +build go1.23
+
+package path
+
+import (
+	"iter"
+	"strings"
+)
+
+// All returns an iterator over the segments of the path and their indexes,
+// yielding the same segments as Segments without allocating a slice for them.
+func (p Path) All() iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		// ".." needs the whole path to be cleaned first
+		if strings.Contains(string(p), "..") {
+			allSegments(p, yield)
+			return
+		}
+
+		s := string(p)
+		i := 0
+		for len(s) > 0 {
+			seg := s
+			if j := strings.IndexByte(s, '/'); j >= 0 {
+				seg, s = s[:j], s[j+1:]
+			} else {
+				s = ""
+			}
+			if seg == "" || seg == "." {
+				continue
+			}
+			if !yield(i, seg) {
+				return
+			}
+			i++
+		}
+
+		// paths without any segment, such as "" or "/", still have the
+		// single one returned by Segments
+		if i == 0 {
+			allSegments(p, yield)
+		}
+	}
+}
+
+func allSegments(p Path, yield func(int, string) bool) {
+	for i, seg := range p.Segments() {
+		if !yield(i, seg) {
+			return
+		}
+	}
+}
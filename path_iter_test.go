@@ -0,0 +1,55 @@
+//go:build go1.23
+// +build go1.23
+
+package path
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n//a/./b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/../b",
+		"/",
+		"",
+	} {
+		var segs []string
+		for i, seg := range FromString(p).All() {
+			if i != len(segs) {
+				t.Fatalf("All(%q) yielded index %d for segment %d", p, i, len(segs))
+			}
+			segs = append(segs, seg)
+		}
+		if expected := FromString(p).Segments(); !reflect.DeepEqual(segs, expected) {
+			t.Fatalf("expected All(%q) to yield %q, got %q", p, expected, segs)
+		}
+	}
+}
+
+func TestAllBreak(t *testing.T) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c")
+	var segs []string
+	for i, seg := range p.All() {
+		segs = append(segs, seg)
+		if i == 2 {
+			break
+		}
+	}
+	expected := []string{"ipfs", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a"}
+	if !reflect.DeepEqual(segs, expected) {
+		t.Fatalf("expected iteration to stop after %q, got %q", expected, segs)
+	}
+}
+
+func BenchmarkAll(b *testing.B) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c/d/e/f")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for range p.All() {
+		}
+	}
+}
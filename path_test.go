@@ -1,8 +1,15 @@
 package path
 
 import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+
+	cid "github.com/ipfs/go-cid"
 )
 
 func TestPathParsing(t *testing.T) {
@@ -38,6 +45,26 @@ func TestPathParsing(t *testing.T) {
 	}
 }
 
+func TestParsePathBareCid(t *testing.T) {
+	cases := map[string]string{
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":                      "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku":         "/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		"bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku/a/b":     "/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku/a/b",
+		"/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku/a": "/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku/a",
+		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":              "/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+	}
+
+	for p, expected := range cases {
+		parsed, err := ParsePath(p)
+		if err != nil {
+			t.Fatalf("ParsePath(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if parsed.String() != expected {
+			t.Fatalf("expected ParsePath(%s) to return %s, not %s", p, expected, parsed)
+		}
+	}
+}
+
 func TestNoComponents(t *testing.T) {
 	for _, s := range []string{
 		"/ipfs/",
@@ -57,9 +84,16 @@ func TestIsJustAKey(t *testing.T) {
 		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":     true,
 		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":   false,
 		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b": false,
-		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":     false,
-		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b": false,
-		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":     true,
+		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":     true,
+		"/ipns/example.com":   true,
+		"/ipns/example.com/":  true,
+		"/ipns/example.com/a": false,
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b":  false,
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":      true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/":     true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/./":   true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/..": true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n//a":   false,
 	}
 
 	for p, expected := range cases {
@@ -82,6 +116,8 @@ func TestPopLastSegment(t *testing.T) {
 		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b":   {"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "b"},
 		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x/y/z": {"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x/y", "z"},
 		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x/y/z": {"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x/y", "z"},
+		"/ipns/example.com/a": {"/ipns/example.com", "a"},
+		"/ipns/example.com":   {"/ipns/example.com", ""},
 	}
 
 	for p, expected := range cases {
@@ -113,3 +149,1268 @@ func TestV0ErrorDueToLowercase(t *testing.T) {
 		t.Fatal("should have meaningful info about case-insensitive fix")
 	}
 }
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":                      "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/./b/../c":           "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/c",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c/d/../../../e/f": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/e/f",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/.":                "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/..":               "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/..":                 "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipns/example.com/a/../b":                                                  "/ipns/example.com/b",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/./a":                        "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+	}
+
+	for p, expected := range cases {
+		normalized, err := FromString(p).Normalize()
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if normalized.String() != expected {
+			t.Fatalf("expected Normalize(%s) to return %v, not %v", p, expected, normalized)
+		}
+	}
+}
+
+func TestNormalizeEscapesRoot(t *testing.T) {
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/..",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/../..",
+		"/ipns/example.com/a/b/../../../c",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/..",
+	} {
+		_, err := FromString(p).Normalize()
+		if err == nil || !strings.Contains(err.Error(), "escapes the path root") {
+			t.Fatalf("expected Normalize(%s) to fail with an escape error, got %v", p, err)
+		}
+	}
+}
+
+func TestParsePathDecoded(t *testing.T) {
+	cases := map[string]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/my%20folder/file": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/my folder/file",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/file%2Bname":      "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/file+name",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a+b":              "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a+b",
+		"/ipns/example.com/%E2%9C%93":                                           "/ipns/example.com/✓",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/my%20folder":            "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/my folder",
+		// encoded slashes stay within their segment, escaped
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%2Fb/c":       `/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a\/b/c`,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%2fb%5Cc%2F":  `/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a\/b\\c\/`,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%5Cb/c%2F%20": `/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a\\b/c\/ `,
+		// as are encoded backslashes, even without an encoded slash
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x%5Cy": `/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x\\y`,
+	}
+
+	for p, expected := range cases {
+		decoded, err := ParsePathDecoded(p)
+		if err != nil {
+			t.Fatalf("ParsePathDecoded failed to parse \"%s\", but should have succeeded: %s", p, err)
+		}
+		if decoded.String() != expected {
+			t.Fatalf("expected ParsePathDecoded(%s) to return %v, not %v", p, expected, decoded)
+		}
+	}
+}
+
+func TestEscapeForURL(t *testing.T) {
+	cases := map[string]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/my folder/file": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/my%20folder/file",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a?b#c%d":        "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%3Fb%23c%25d",
+		"/ipns/example.com/✓/":                                 "/ipns/example.com/%E2%9C%93/",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a b":   "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%20b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+	}
+
+	for p, expected := range cases {
+		escaped := FromString(p).EscapeForURL()
+		if escaped != expected {
+			t.Fatalf("expected EscapeForURL(%s) to return %s, not %s", p, expected, escaped)
+		}
+
+		decoded, err := ParsePathDecoded(escaped)
+		if err != nil {
+			t.Fatalf("ParsePathDecoded(%s) failed: %s", escaped, err)
+		}
+		parsed, err := ParsePath(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != parsed {
+			t.Fatalf("expected %s to decode back to %s, got %s", escaped, parsed, decoded)
+		}
+	}
+}
+
+func TestEscapeForURLDecoded(t *testing.T) {
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%2Fb/c",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%2Fb%5Cc%2F",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a%5C%2Fb/%5C",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/%2F%2F/x%5Cy/",
+		"/ipns/example.com/a%20b%2Fc",
+	} {
+		decoded, err := ParsePathDecoded(p)
+		if err != nil {
+			t.Fatalf("ParsePathDecoded(%s) failed: %s", p, err)
+		}
+		if escaped := decoded.EscapeForURL(); escaped != p {
+			t.Fatalf("expected %s to be escaped back to %s, got %s", decoded, p, escaped)
+		}
+	}
+}
+
+func TestToURL(t *testing.T) {
+	const root = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	cases := []struct {
+		p, gateway, expected string
+	}{
+		{"/ipfs/" + root + "/a/b", "https://ipfs.io", "https://ipfs.io/ipfs/" + root + "/a/b"},
+		{"/ipfs/" + root + "/a/b", "https://ipfs.io/", "https://ipfs.io/ipfs/" + root + "/a/b"},
+		{"/ipfs/" + root + "/my folder/my file.txt", "https://ipfs.io", "https://ipfs.io/ipfs/" + root + "/my%20folder/my%20file.txt"},
+		{"/ipfs/" + root + "/a?b#c", "http://localhost:8080", "http://localhost:8080/ipfs/" + root + "/a%3Fb%23c"},
+		{root + "/dir/", "https://example.com/gw/", "https://example.com/gw/ipfs/" + root + "/dir/"},
+		{"/ipns/example.com/index.html", "https://dweb.link", "https://dweb.link/ipns/example.com/index.html"},
+	}
+	for _, tc := range cases {
+		u, err := FromString(tc.p).ToURL(tc.gateway)
+		if err != nil {
+			t.Fatalf("ToURL(%s, %s) failed: %s", tc.p, tc.gateway, err)
+		}
+		if u != tc.expected {
+			t.Fatalf("expected ToURL(%s, %s) to return %s, not %s", tc.p, tc.gateway, tc.expected, u)
+		}
+	}
+
+	for _, gateway := range []string{
+		"",
+		"ipfs.io",
+		"/ipfs",
+		"ftp://ipfs.io",
+		"https://",
+		"https://ipfs.io/?a=b",
+		"https://ipfs.io/#top",
+		"https://ipfs.io:port",
+	} {
+		if u, err := FromString("/ipfs/" + root).ToURL(gateway); err == nil {
+			t.Fatalf("expected gateway %q to be rejected, got %s", gateway, u)
+		}
+	}
+
+	for _, p := range []string{"", "/ipfs/", "/ipfs/notacid/a", "/foo/" + root} {
+		if u, err := FromString(p).ToURL("https://ipfs.io"); err == nil {
+			t.Fatalf("expected path %q to be rejected, got %s", p, u)
+		}
+	}
+}
+
+func TestParseDNSLinkPath(t *testing.T) {
+	cases := map[string]string{
+		"en.wikipedia-on-ipfs.org":         "/ipns/en.wikipedia-on-ipfs.org",
+		"en.wikipedia-on-ipfs.org/":        "/ipns/en.wikipedia-on-ipfs.org/",
+		"en.wikipedia-on-ipfs.org/wiki/":   "/ipns/en.wikipedia-on-ipfs.org/wiki/",
+		"docs.ipfs.tech/how-to/index.html": "/ipns/docs.ipfs.tech/how-to/index.html",
+		"Example.COM/a b":                  "/ipns/Example.COM/a b",
+		"xn--bcher-kva.example/":           "/ipns/xn--bcher-kva.example/",
+	}
+	for host, expected := range cases {
+		p, err := ParseDNSLinkPath(host)
+		if err != nil {
+			t.Fatalf("ParseDNSLinkPath(%s) failed: %s", host, err)
+		}
+		if p.String() != expected {
+			t.Fatalf("expected ParseDNSLinkPath(%s) to return %s, not %s", host, expected, p)
+		}
+	}
+
+	for _, host := range []string{
+		"",
+		"/",
+		"localhost",
+		"https://en.wikipedia-on-ipfs.org/wiki/",
+		"/ipns/en.wikipedia-on-ipfs.org",
+		"en.wikipedia-on-ipfs.org:8080/wiki",
+		"user@example.com",
+		"example..com",
+		".example.com",
+		"example.com.",
+		"-example.com",
+		"example-.com",
+		"exa_mple.com",
+		"example.com/a\nb",
+	} {
+		_, err := ParseDNSLinkPath(host)
+		if err == nil {
+			t.Fatalf("expected ParseDNSLinkPath(%q) to fail", host)
+		}
+		if !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected the error for %q to match ErrBadPath, got %s", host, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const root = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	for _, p := range []string{
+		"/ipfs/" + root,
+		"/ipfs/" + root + "/a/b/",
+		"/ipns/example.com/a",
+		root + "/a",
+	} {
+		if issues := FromString(p).Validate(); len(issues) != 0 {
+			t.Fatalf("expected %s to be valid, got %v", p, issues)
+		}
+	}
+
+	type issue struct {
+		kind   PathIssueKind
+		offset int
+	}
+	cases := []struct {
+		p        string
+		expected []issue
+	}{
+		{"", []issue{{IssueMissingRoot, 0}}},
+		{"/ipfs/", []issue{{IssueMissingRoot, 6}}},
+		{"/ipfs", []issue{{IssueMissingRoot, 5}}},
+		{"ipfs/" + root, []issue{{IssueBadNamespace, 0}}},
+		{"/foo/bar/a//b", []issue{{IssueBadNamespace, 1}, {IssueEmptySegment, 11}}},
+		{"/ipfs/notacid/a\nb//c/", []issue{{IssueInvalidRoot, 6}, {IssueControlCharacter, 15}, {IssueEmptySegment, 18}}},
+		{"/ipns//x\x7f", []issue{{IssueMissingRoot, 6}, {IssueControlCharacter, 8}}},
+		{"/ipld/" + root + "//\x00/", []issue{{IssueEmptySegment, 53}, {IssueControlCharacter, 54}}},
+		{"notacid//a", []issue{{IssueInvalidRoot, 0}, {IssueEmptySegment, 8}}},
+	}
+	for _, tc := range cases {
+		issues := FromString(tc.p).Validate()
+		if len(issues) != len(tc.expected) {
+			t.Fatalf("expected %d issues in %q, got %v", len(tc.expected), tc.p, issues)
+		}
+		for i, iss := range issues {
+			if iss.Kind != tc.expected[i].kind || iss.Offset != tc.expected[i].offset {
+				t.Fatalf("expected issue %d of %q to be a %s at offset %d, got a %s at offset %d (%s)", i, tc.p, tc.expected[i].kind, tc.expected[i].offset, iss.Kind, iss.Offset, iss.Message)
+			}
+			if iss.Message == "" {
+				t.Fatalf("expected issue %d of %q to have a message", i, tc.p)
+			}
+		}
+		if _, err := ParsePath(tc.p); err == nil && tc.expected[0].kind != IssueEmptySegment {
+			t.Fatalf("expected ParsePath(%q) to fail", tc.p)
+		}
+	}
+}
+
+func TestParsePathDecodedErrors(t *testing.T) {
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/%zz",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/100%",
+		"/ipfs/Qmdf%54bBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+	} {
+		_, err := ParsePathDecoded(p)
+		if !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected ParsePathDecoded(%s) to fail with ErrBadPath, got %v", p, err)
+		}
+	}
+}
+
+func TestParsePathWithLimits(t *testing.T) {
+	const root = "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	atLimit := root + "/" + strings.Repeat("a", 8) + "/b"
+	overLimit := root + "/a/" + strings.Repeat("a", 9)
+
+	if _, err := ParsePathWithLimits(atLimit, 8); err != nil {
+		t.Fatalf("ParsePathWithLimits(%s, 8) failed: %s", atLimit, err)
+	}
+
+	_, err := ParsePathWithLimits(overLimit, 8)
+	if !errors.Is(err, ErrSegmentTooLong) || !errors.Is(err, ErrBadPath) {
+		t.Fatalf("expected ParsePathWithLimits(%s, 8) to fail with ErrSegmentTooLong, got %v", overLimit, err)
+	}
+
+	// the root is not subject to the limit
+	if _, err := ParsePathWithLimits(root, 8); err != nil {
+		t.Fatalf("ParsePathWithLimits(%s, 8) failed: %s", root, err)
+	}
+
+	// zero means unlimited
+	if _, err := ParsePathWithLimits(overLimit, 0); err != nil {
+		t.Fatalf("ParsePathWithLimits(%s, 0) failed: %s", overLimit, err)
+	}
+
+	// invalid paths are still rejected
+	if _, err := ParsePathWithLimits("/ipfs/", 0); !errors.Is(err, ErrBadPath) {
+		t.Fatalf("expected ParsePathWithLimits(/ipfs/, 0) to fail with ErrBadPath, got %v", err)
+	}
+}
+
+func TestHasTrailingSlash(t *testing.T) {
+	cases := map[string]bool{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/dir/": true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/":     true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/dir":  false,
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":            false,
+		"/": false,
+		"":  false,
+	}
+
+	for p, expected := range cases {
+		if FromString(p).HasTrailingSlash() != expected {
+			t.Fatalf("expected HasTrailingSlash(%s) to be %t", p, expected)
+		}
+	}
+
+	// parsing keeps the trailing slash
+	p, err := ParsePath("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasTrailingSlash() {
+		t.Fatalf("ParsePath dropped the trailing slash of %s", p)
+	}
+}
+
+func TestReplaceRoot(t *testing.T) {
+	const cidStr = "bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	cases := []struct {
+		p, namespace, root, expected string
+	}{
+		{"/ipns/example.com/a/b", "ipfs", cidStr, "/ipfs/" + cidStr + "/a/b"},
+		{"/ipns/example.com", "ipfs", cidStr, "/ipfs/" + cidStr},
+		{"/ipns/example.com/a/", "ipfs", cidStr, "/ipfs/" + cidStr + "/a/"},
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "ipld", cidStr, "/ipld/" + cidStr + "/a"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "ipns", "example.com", "/ipns/example.com/a"},
+	}
+
+	for _, tc := range cases {
+		replaced, err := FromString(tc.p).ReplaceRoot(tc.namespace, tc.root)
+		if err != nil {
+			t.Fatalf("ReplaceRoot(%s, %s, %s) failed: %s", tc.p, tc.namespace, tc.root, err)
+		}
+		if replaced.String() != tc.expected {
+			t.Fatalf("expected ReplaceRoot(%s, %s, %s) to return %s, not %s", tc.p, tc.namespace, tc.root, tc.expected, replaced)
+		}
+	}
+
+	for _, tc := range []struct {
+		p, namespace, root string
+	}{
+		{"/ipns/example.com/a", "ipfs", "example.com"},
+		{"/ipns/example.com/a", "foo", cidStr},
+		{"/ipns/example.com/a", "ipfs", ""},
+		{"/ipfs/foo/a", "ipfs", cidStr},
+	} {
+		if _, err := FromString(tc.p).ReplaceRoot(tc.namespace, tc.root); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected ReplaceRoot(%s, %s, %s) to fail with ErrBadPath, got %v", tc.p, tc.namespace, tc.root, err)
+		}
+	}
+}
+
+func TestSplitAt(t *testing.T) {
+	const root = "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	cases := []struct {
+		p    string
+		i    int
+		head string
+		tail []string
+	}{
+		{root + "/a/b/c", 0, root, []string{"a", "b", "c"}},
+		{root + "/a/b/c", 1, root + "/a", []string{"b", "c"}},
+		{root + "/a/b/c", 2, root + "/a/b", []string{"c"}},
+		{root + "/a/b/c", 3, root + "/a/b/c", []string{}},
+		{root, 0, root, []string{}},
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", 0, root, []string{"a"}},
+		{"/ipns/example.com/a/b", 1, "/ipns/example.com/a", []string{"b"}},
+	}
+
+	for _, tc := range cases {
+		head, tail, err := FromString(tc.p).SplitAt(tc.i)
+		if err != nil {
+			t.Fatalf("SplitAt(%s, %d) failed: %s", tc.p, tc.i, err)
+		}
+		if head.String() != tc.head {
+			t.Fatalf("expected SplitAt(%s, %d) to return head %s, not %s", tc.p, tc.i, tc.head, head)
+		}
+		if !reflect.DeepEqual(tail, tc.tail) {
+			t.Fatalf("expected SplitAt(%s, %d) to return tail %v, not %v", tc.p, tc.i, tc.tail, tail)
+		}
+	}
+
+	for _, i := range []int{-1, 4} {
+		if _, _, err := FromString(root + "/a/b/c").SplitAt(i); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected SplitAt(%d) to fail with ErrBadPath, got %v", i, err)
+		}
+	}
+	if _, _, err := FromString("/ipfs/foo/a").SplitAt(0); err == nil {
+		t.Fatal("expected SplitAt to fail on an invalid path")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	sorted := []Path{
+		"a",
+		"a/b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/c",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/b",
+		"/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		"/ipns/example.com",
+		"/ipns/example.com/a",
+		"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8",
+	}
+
+	shuffled := make([]Path, len(sorted))
+	for i, j := range rand.New(rand.NewSource(1)).Perm(len(sorted)) {
+		shuffled[i] = sorted[j]
+	}
+	sort.Slice(shuffled, func(i, j int) bool {
+		return Compare(shuffled[i], shuffled[j]) < 0
+	})
+	if !reflect.DeepEqual(shuffled, sorted) {
+		t.Fatalf("expected sorted paths %q, got %q", sorted, shuffled)
+	}
+
+	for _, tc := range []struct {
+		a, b     Path
+		expected int
+	}{
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/", 0},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", 1},
+		{"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "/ipns/example.com", -1},
+		{"a/b", "/ipfs/a/b", -1},
+	} {
+		if c := Compare(tc.a, tc.b); c != tc.expected {
+			t.Fatalf("expected Compare(%s, %s) to be %d, got %d", tc.a, tc.b, tc.expected, c)
+		}
+		if c := Compare(tc.b, tc.a); c != -tc.expected {
+			t.Fatalf("expected Compare(%s, %s) to be %d, got %d", tc.b, tc.a, -tc.expected, c)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		p, pattern string
+		matches    bool
+	}{
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/blog/2021/index.html", "/ipfs/*/blog/*/index.html", true},
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/blog/2021/index.html", "/ipfs/*/blog/*/index.html", true},
+		{"/ipns/example.com/blog/2021/index.html", "/*/*/blog/20[0-9][0-9]/*.html", true},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/blog/2021/", "/ipfs/*/blog/*/", true},
+		{"/ipns/example.com/blog/2021/index.html", "/ipfs/*/blog/*/index.html", false},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/blog/2021/about.html", "/ipfs/*/blog/*/index.html", false},
+		// a '*' matches a single segment
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/blog/2021/01/index.html", "/ipfs/*/blog/*/index.html", false},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/blog/index.html", "/ipfs/*/blog/*/index.html", false},
+	}
+
+	for _, tc := range cases {
+		matches, err := FromString(tc.p).Matches(tc.pattern)
+		if err != nil {
+			t.Fatalf("Matches(%s, %s) failed: %s", tc.p, tc.pattern, err)
+		}
+		if matches != tc.matches {
+			t.Fatalf("expected Matches(%s, %s) to be %t", tc.p, tc.pattern, tc.matches)
+		}
+	}
+
+	if _, err := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n").Matches("/ipfs/[/a"); err == nil {
+		t.Fatal("expected a malformed pattern to fail")
+	}
+}
+
+func TestParsePathStrict(t *testing.T) {
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+		"/ipns/example.com/a",
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+	} {
+		parsed, err := ParsePathStrict(p)
+		if err != nil {
+			t.Fatalf("ParsePathStrict(%s) failed: %s", p, err)
+		}
+		if parsed.String() != p {
+			t.Fatalf("expected ParsePathStrict(%s) to return %s, not %s", p, p, parsed)
+		}
+	}
+
+	for _, p := range []string{
+		"/foo/bar",
+		"/foo/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+	} {
+		_, err := ParsePathStrict(p)
+		if !errors.Is(err, ErrUnknownNamespace) || !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected ParsePathStrict(%s) to fail with ErrUnknownNamespace, got %v", p, err)
+		}
+	}
+
+	// other errors are not about the namespace
+	if _, err := ParsePathStrict("/ipfs/foo"); err == nil || errors.Is(err, ErrUnknownNamespace) {
+		t.Fatalf("expected ParsePathStrict(/ipfs/foo) to fail with another error than ErrUnknownNamespace, got %v", err)
+	}
+
+	// ParsePath still takes keys as /ipfs/ paths, but rejects unknown namespaces
+	if _, err := ParsePath("/foo/bar"); !errors.Is(err, ErrUnknownNamespace) {
+		t.Fatalf("expected ParsePath(/foo/bar) to fail with ErrUnknownNamespace, got %v", err)
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	cases := []struct {
+		a, b, expected string
+	}{
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/d", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b"},
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"},
+		{"/ipns/example.com/a/b", "/ipns/example.com/a/c", "/ipns/example.com/a"},
+	}
+
+	for _, tc := range cases {
+		prefix, err := CommonPrefix(FromString(tc.a), FromString(tc.b))
+		if err != nil {
+			t.Fatalf("CommonPrefix(%s, %s) failed: %s", tc.a, tc.b, err)
+		}
+		if prefix.String() != tc.expected {
+			t.Fatalf("expected CommonPrefix(%s, %s) to return %s, not %s", tc.a, tc.b, tc.expected, prefix)
+		}
+	}
+
+	for _, tc := range []struct {
+		a, b string
+	}{
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku/a"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/foo/a"},
+	} {
+		if _, err := CommonPrefix(FromString(tc.a), FromString(tc.b)); err == nil {
+			t.Fatalf("expected CommonPrefix(%s, %s) to fail", tc.a, tc.b)
+		}
+	}
+}
+
+func TestParsePathControlCharacters(t *testing.T) {
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a\nb",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a\x00b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/\x7f",
+		"/ipns/example.com\t/a",
+	} {
+		if _, err := ParsePath(p); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected ParsePath(%q) to fail with ErrBadPath, got %v", p, err)
+		}
+		if parsed, err := ParsePathLenient(p); err != nil || parsed.String() != p {
+			t.Fatalf("expected ParsePathLenient(%q) to succeed, got %q, %v", p, parsed, err)
+		}
+	}
+
+	if _, err := FromSegments("/ipfs/", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a\nb"); !errors.Is(err, ErrBadPath) {
+		t.Fatalf("expected FromSegments to reject a newline, got %v", err)
+	}
+	if _, err := FromSegments("/ipfs/", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a\x00b"); !errors.Is(err, ErrBadPath) {
+		t.Fatalf("expected FromSegments to reject a NUL byte, got %v", err)
+	}
+
+	// other characters are fine
+	if _, err := ParsePath("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a b/✓/~"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// equalCases are pairs of paths and whether they are Equal.
+var equalCases = []struct {
+	a, b  string
+	equal bool
+}{
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", true},
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", true},
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", true},
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a//b", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", true},
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", false},
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/b", false},
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b", false},
+	{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", true},
+	// relative paths are not rooted under /ipfs/
+	{"a/b", "a/b/", true},
+	{"a/b", "/ipfs/a/b", false},
+	{"a", "/ipfs/a", false},
+	// same multihash as CIDv0 and CIDv1
+	{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", false},
+}
+
+func TestEqual(t *testing.T) {
+	for _, c := range equalCases {
+		if FromString(c.a).Equal(FromString(c.b)) != c.equal {
+			t.Fatalf("expected Equal(%s, %s) to return %v", c.a, c.b, c.equal)
+		}
+		if FromString(c.b).Equal(FromString(c.a)) != c.equal {
+			t.Fatalf("expected Equal(%s, %s) to return %v", c.b, c.a, c.equal)
+		}
+	}
+}
+
+func TestParent(t *testing.T) {
+	cases := map[string]string{
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":       "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipns/example.com": "/ipns/example.com",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":     "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":           "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipns/example.com/x/y/":                                     "/ipns/example.com/x",
+		// relative paths stay relative
+		"a/b":    "a",
+		"a/b/c/": "a/b",
+		"a":      ".",
+		".":      ".",
+	}
+
+	for p, expected := range cases {
+		parent := FromString(p).Parent()
+		if parent.String() != expected {
+			t.Fatalf("expected Parent(%s) to return %v, not %v", p, expected, parent)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b")
+	b, err := json.Marshal(map[string]Path{"path": p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"path":"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b"}`
+	if string(b) != expected {
+		t.Fatalf("expected %s, got %s", expected, b)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var v struct {
+		Path Path
+	}
+	err := json.Unmarshal([]byte(`{"Path":"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a"}`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Path != "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a" {
+		t.Fatalf("unexpected path %s", v.Path)
+	}
+
+	// null leaves the path unchanged, as for an optional field
+	if err := json.Unmarshal([]byte(`{"Path":null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Path != "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a" {
+		t.Fatalf("unexpected path %s after unmarshaling null", v.Path)
+	}
+	var empty Path
+	if err := json.Unmarshal([]byte(`null`), &empty); err != nil || empty != "" {
+		t.Fatalf("expected unmarshaling null to leave an empty path, got %q, %v", empty, err)
+	}
+
+	for _, s := range []string{
+		`{"Path":""}`,
+		`{"Path":"/ipfs/foo"}`,
+		`{"Path":"/unknown/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"}`,
+		`{"Path":42}`,
+	} {
+		if err := json.Unmarshal([]byte(s), &v); err == nil {
+			t.Fatalf("expected unmarshaling %s to fail", s)
+		}
+	}
+}
+
+func TestTextMarshaling(t *testing.T) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b")
+	b, err := p.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Path
+	if err := decoded.UnmarshalText(b); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != p {
+		t.Fatalf("expected %s, got %s", p, decoded)
+	}
+
+	// the text is parsed, so bare keys get their namespace
+	if err := decoded.UnmarshalText([]byte("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n" {
+		t.Fatalf("unexpected path %s", decoded)
+	}
+
+	for _, s := range []string{
+		"",
+		"/ipfs/foo",
+		"/unknown/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+	} {
+		decoded = p
+		if err := decoded.UnmarshalText([]byte(s)); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected unmarshaling %q to fail with ErrBadPath, got %v", s, err)
+		}
+		if decoded != p {
+			t.Fatalf("failed unmarshaling of %q modified the path to %s", s, decoded)
+		}
+	}
+}
+
+func TestSplitAbsPath(t *testing.T) {
+	cases := map[string][]string{
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":         {"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a"},
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":     {"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"},
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b": {"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a", "b"},
+	}
+
+	for p, expected := range cases {
+		c, rest, err := SplitAbsPath(FromString(p))
+		if err != nil {
+			t.Fatalf("SplitAbsPath(%s) failed, but should have succeeded: %s", p, err)
+		}
+		expectedCid, err := cid.Decode(expected[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Equals(expectedCid) {
+			t.Fatalf("expected SplitAbsPath(%s) to return root %v, not %v", p, expected[0], c)
+		}
+		if Join(rest) != Join(expected[1:]) {
+			t.Fatalf("expected SplitAbsPath(%s) to return rest %v, not %v", p, expected[1:], rest)
+		}
+	}
+
+	// names are left to SplitAbsPathName, even when they are CIDs
+	for _, p := range []string{
+		"/ipns/example.com/a",
+		"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8",
+		"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8/a",
+	} {
+		if _, _, err := SplitAbsPath(FromString(p)); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected SplitAbsPath(%s) to fail with ErrBadPath, got %v", p, err)
+		}
+	}
+}
+
+func TestCidVersions(t *testing.T) {
+	v0, err := cid.Decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+
+	for _, c := range []cid.Cid{v0, v1} {
+		s := c.String()
+		for _, txt := range []string{s, s + "/a", "/ipfs/" + s + "/a", "/ipld/" + s + "/a"} {
+			p, err := ParsePath(txt)
+			if err != nil {
+				t.Fatalf("ParsePath(%s) failed: %s", txt, err)
+			}
+			root, rest, err := SplitAbsPath(p)
+			if err != nil {
+				t.Fatalf("SplitAbsPath(%s) failed: %s", p, err)
+			}
+			if !root.Equals(c) {
+				t.Fatalf("expected SplitAbsPath(%s) to return root %s (version %d), not %s", p, c, c.Version(), root)
+			}
+			if len(rest) > 1 || (len(rest) == 1 && rest[0] != "a") {
+				t.Fatalf("expected SplitAbsPath(%s) to return rest [a] or [], not %v", p, rest)
+			}
+		}
+
+		p := FromCid(c)
+		if p != Path("/ipfs/"+s) {
+			t.Fatalf("expected FromCid(%s) to be /ipfs/%s, not %s", s, s, p)
+		}
+		if _, err := ParsePath(p.String()); err != nil {
+			t.Fatalf("ParsePath(FromCid(%s)) failed: %s", s, err)
+		}
+		root, _, err := SplitAbsPath(FromString(s + "/a"))
+		if err != nil || !root.Equals(c) {
+			t.Fatalf("expected SplitAbsPath(FromString(%s/a)) to return root %s, got %s (%v)", s, s, root, err)
+		}
+	}
+}
+
+func TestSplitAbsPathName(t *testing.T) {
+	cases := map[string][]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":               {"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a"},
+		"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8": {"k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"},
+		"/ipns/example.com":      {"example.com"},
+		"/ipns/example.com/a/b/": {"example.com", "a", "b"},
+		"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8/x": {"k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8", "x"},
+	}
+
+	for p, expected := range cases {
+		name, rest, err := SplitAbsPathName(FromString(p))
+		if err != nil {
+			t.Fatalf("SplitAbsPathName(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if name != expected[0] {
+			t.Fatalf("expected SplitAbsPathName(%s) to return name %v, not %v", p, expected[0], name)
+		}
+		if Join(rest) != Join(expected[1:]) {
+			t.Fatalf("expected SplitAbsPathName(%s) to return rest %v, not %v", p, expected[1:], rest)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	cases := map[string]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":   "ipfs",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a": "ipfs",
+		"/ipns/example.com/a/b":                                  "ipns",
+		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":   "ipns",
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":   "ipld",
+	}
+
+	for p, expected := range cases {
+		ns, err := FromString(p).Namespace()
+		if err != nil {
+			t.Fatalf("Namespace(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if ns != expected {
+			t.Fatalf("expected Namespace(%s) to return %v, not %v", p, expected, ns)
+		}
+	}
+
+	for _, p := range []string{
+		"",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+		"ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/foo/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+	} {
+		if _, err := FromString(p).Namespace(); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected Namespace(%s) to fail, got %v", p, err)
+		}
+	}
+}
+
+func TestIsAbsolute(t *testing.T) {
+	cases := map[string]bool{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":   true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a": true,
+		"/ipns/example.com/a": true,
+		"a/b":                 false,
+		"a":                   false,
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n": false,
+	}
+
+	for p, expected := range cases {
+		pth := FromString(p)
+		if pth.IsAbsolute() != expected {
+			t.Fatalf("expected IsAbsolute(%s) to return %v, not %v", p, expected, !expected)
+		}
+		if pth.IsRelative() == expected {
+			t.Fatalf("expected IsRelative(%s) to return %v, not %v", p, !expected, expected)
+		}
+	}
+}
+
+func TestJoinStrict(t *testing.T) {
+	joined, err := JoinStrict([]string{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a", "b"})
+	if err != nil {
+		t.Fatalf("JoinStrict failed, but should have succeeded: %s", err)
+	}
+	if joined != "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b" {
+		t.Fatalf("unexpected JoinStrict result: %s", joined)
+	}
+
+	for _, segs := range [][]string{
+		{"a", "b/c"},
+		{"a", "", "c"},
+		{""},
+	} {
+		if _, err := JoinStrict(segs); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected JoinStrict(%q) to fail, got %v", segs, err)
+		}
+	}
+}
+
+func TestTrimPrefix(t *testing.T) {
+	base := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site")
+	cases := map[string][]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/css/main.css": {"css", "main.css"},
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/index.html":         {"index.html"},
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site":              {},
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/":             {},
+	}
+
+	for p, expected := range cases {
+		rest, ok := FromString(p).TrimPrefix(base)
+		if !ok {
+			t.Fatalf("expected %s to be a prefix of %s", base, p)
+		}
+		if len(rest) != len(expected) || strings.Join(rest, "/") != strings.Join(expected, "/") {
+			t.Fatalf("expected TrimPrefix(%s) to return %v, not %v", p, expected, rest)
+		}
+	}
+
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/sites/index.html",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/index.html",
+		"/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR/site/index.html",
+	} {
+		if rest, ok := FromString(p).TrimPrefix(base); ok {
+			t.Fatalf("expected %s not to be a prefix of %s, got %v", base, p, rest)
+		}
+	}
+}
+
+func TestTrimSuffix(t *testing.T) {
+	const root = "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	cases := []struct {
+		p        string
+		segs     []string
+		expected string
+	}{
+		{root + "/site/index.html", []string{"index.html"}, root + "/site"},
+		{root + "/site/index.html", []string{"site", "index.html"}, root},
+		{root + "/site/index.html/", []string{"index.html"}, root + "/site"},
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/index.html", []string{"index.html"}, root},
+		{"/ipns/example.com/a/index.html", []string{"index.html"}, "/ipns/example.com/a"},
+		{root + "/site", nil, root + "/site"},
+		// relative paths stay relative
+		{"a/b", []string{"b"}, "a"},
+		{"a/b/", []string{"b"}, "a"},
+		{"a/b", []string{"a", "b"}, "."},
+	}
+	for _, tc := range cases {
+		trimmed, ok := FromString(tc.p).TrimSuffix(tc.segs...)
+		if !ok {
+			t.Fatalf("expected %s to end with %v", tc.p, tc.segs)
+		}
+		if trimmed.String() != tc.expected {
+			t.Fatalf("expected TrimSuffix(%s, %v) to return %s, not %s", tc.p, tc.segs, tc.expected, trimmed)
+		}
+	}
+
+	for _, tc := range []struct {
+		p    string
+		segs []string
+	}{
+		{root + "/site/index.html", []string{"index.htm"}},
+		{root + "/site/index.html", []string{"site"}},
+		{root + "/site/my-index.html", []string{"index.html"}},
+		// the root cannot be trimmed
+		{root + "/site", []string{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "site"}},
+		{root + "/site", []string{"a", "b", "site"}},
+		{root, []string{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"}},
+		{"a/b", []string{"a"}},
+		{"a/b", []string{"x", "a", "b"}},
+	} {
+		trimmed, ok := FromString(tc.p).TrimSuffix(tc.segs...)
+		if ok {
+			t.Fatalf("expected %s not to end with %v, got %s", tc.p, tc.segs, trimmed)
+		}
+		if trimmed.String() != tc.p {
+			t.Fatalf("expected TrimSuffix(%s, %v) to return the path unchanged, not %s", tc.p, tc.segs, trimmed)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	cases := map[string]int{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":   0,
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":         0,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a": 1,
+		"/ipns/example.com/a": 1,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c/d/": 4,
+		// relative paths have no root
+		"a":      1,
+		"a/b":    2,
+		"a/b/c/": 3,
+		".":      0,
+	}
+
+	for p, expected := range cases {
+		if depth := FromString(p).Depth(); depth != expected {
+			t.Fatalf("expected Depth(%s) to return %d, not %d", p, expected, depth)
+		}
+	}
+}
+
+func TestFromSegments(t *testing.T) {
+	p, err := FromSegments("/ipfs/", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a", "b")
+	if err != nil {
+		t.Fatalf("FromSegments failed, but should have succeeded: %s", err)
+	}
+	if p != "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b" {
+		t.Fatalf("unexpected FromSegments result: %s", p)
+	}
+
+	for _, segs := range [][]string{
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "", "b"},
+		{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a", ""},
+	} {
+		if p, err := FromSegments("/ipfs/", segs...); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected FromSegments(%q) to fail, got %s, %v", segs, p, err)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	cases := map[string]uint64{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":                cid.DagProtobuf,
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":                        cid.DagProtobuf,
+		"/ipld/bafyreidykglsfhoixmivffc5uwhcgshx4j465xwqntbmu43nb2dzqwfvae/a/b": cid.DagCBOR,
+		"/ipfs/bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku":     cid.Raw,
+	}
+
+	for p, expected := range cases {
+		c, err := FromString(p).RootCid()
+		if err != nil {
+			t.Fatalf("RootCid(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if c.Prefix().Codec != expected {
+			t.Fatalf("expected RootCid(%s) to have codec %x, not %x", p, expected, c.Prefix().Codec)
+		}
+		codec, err := FromString(p).Codec()
+		if err != nil {
+			t.Fatalf("Codec(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if codec != expected {
+			t.Fatalf("expected Codec(%s) to return %x, not %x", p, expected, codec)
+		}
+	}
+
+	for _, p := range []string{
+		"/ipns/example.com/a",
+		"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8",
+	} {
+		if _, err := FromString(p).Codec(); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected Codec(%s) to fail, got %v", p, err)
+		}
+	}
+}
+
+func TestResolveRelative(t *testing.T) {
+	base := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/blog")
+	cases := map[string]string{
+		"post.html":           "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/blog/post.html",
+		"./post.html":         "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/blog/post.html",
+		"../css/main.css":     "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/css/main.css",
+		"../../index.html":    "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/index.html",
+		"/ipns/example.com/a": "/ipns/example.com/a",
+	}
+
+	for rel, expected := range cases {
+		p, err := ResolveRelative(base, rel)
+		if err != nil {
+			t.Fatalf("ResolveRelative(%s) failed, but should have succeeded: %s", rel, err)
+		}
+		if p.String() != expected {
+			t.Fatalf("expected ResolveRelative(%s) to return %s, not %s", rel, expected, p)
+		}
+	}
+
+	if p, err := ResolveRelative(base, "../../../index.html"); !errors.Is(err, ErrBadPath) {
+		t.Fatalf("expected ResolveRelative to fail escaping the root, got %s, %v", p, err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	cases := map[string]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n//a//./b/../c/": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/c",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b":           "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/../../a":       "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a",
+		"//ipns//example.com/./a/..":                                         "/ipns/example.com",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/../..":             "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipfs/..": "/ipfs/..",
+		"/":        "/",
+		"":         "",
+	}
+
+	for p, expected := range cases {
+		if cleaned := Clean(FromString(p)); cleaned.String() != expected {
+			t.Fatalf("expected Clean(%s) to return %s, not %s", p, expected, cleaned)
+		}
+	}
+}
+
+func TestSplitRoot(t *testing.T) {
+	cases := map[string][]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b": {"ipfs", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a", "b"},
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":           {"ipfs", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"},
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a":   {"ipld", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "a"},
+		"/ipns/example.com/index.html":                             {"ipns", "example.com", "index.html"},
+	}
+
+	for p, expected := range cases {
+		ns, root, rest, err := FromString(p).SplitRoot()
+		if err != nil {
+			t.Fatalf("SplitRoot(%s) failed, but should have succeeded: %s", p, err)
+		}
+		if ns != expected[0] || root != expected[1] || strings.Join(rest, "/") != strings.Join(expected[2:], "/") {
+			t.Fatalf("expected SplitRoot(%s) to return %v, not %s %s %v", p, expected, ns, root, rest)
+		}
+	}
+
+	for _, p := range []string{
+		"",
+		"/ipfs/",
+		"/ipfs/foo/a",
+		"/foo/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+	} {
+		if _, _, _, err := FromString(p).SplitRoot(); !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected SplitRoot(%s) to fail, got %v", p, err)
+		}
+	}
+}
+
+func TestHasPrefix(t *testing.T) {
+	prefix := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site")
+	cases := map[string]bool{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site":            true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site/index.html": true,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/sites":           false,
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":                 false,
+		"/ipfs/QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR/site":            false,
+		"/ipns/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/site":            false,
+	}
+
+	for p, expected := range cases {
+		if FromString(p).HasPrefix(prefix) != expected {
+			t.Fatalf("expected HasPrefix(%s) to return %v", p, expected)
+		}
+	}
+}
+
+func TestBase(t *testing.T) {
+	cases := map[string]string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c.txt": "c.txt",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/":      "b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":           "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":                 "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipns/example.com/": "example.com",
+	}
+
+	for p, expected := range cases {
+		if base := FromString(p).Base(); base != expected {
+			t.Fatalf("expected Base(%s) to return %s, not %s", p, expected, base)
+		}
+	}
+}
+
+func TestSegmentsCopy(t *testing.T) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/c/b/a")
+	segs := p.Segments()
+	segs[1] = "mutated"
+	sort.Strings(segs)
+	segs = segs[:1]
+	_ = append(segs, "appended")
+
+	expected := []string{"ipfs", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", "c", "b", "a"}
+	if Join(p.Segments()) != Join(expected) {
+		t.Fatalf("expected Segments() to return %v, not %v", expected, p.Segments())
+	}
+}
+
+func TestWithSegment(t *testing.T) {
+	cases := map[string]string{
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":             "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n":       "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/":      "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/x",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c": "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c/x",
+		"/ipns/example.com/a": "/ipns/example.com/a/x",
+	}
+
+	for p, expected := range cases {
+		orig := FromString(p)
+		child, err := orig.WithSegment("x")
+		if err != nil {
+			t.Fatalf("WithSegment failed on %s, but should have succeeded: %s", p, err)
+		}
+		if child.String() != expected {
+			t.Fatalf("expected WithSegment(%s) to return %v, not %v", p, expected, child)
+		}
+		if orig.String() != p {
+			t.Fatalf("WithSegment modified %s", p)
+		}
+	}
+
+	for _, seg := range []string{"a/b", "/", ""} {
+		_, err := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n").WithSegment(seg)
+		if !errors.Is(err, ErrBadPath) {
+			t.Fatalf("expected WithSegment(%q) to fail, got %v", seg, err)
+		}
+	}
+}
+
+func BenchmarkPathString(b *testing.B) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = p.String()
+	}
+}
+
+func BenchmarkIsJustAKey(b *testing.B) {
+	p := FromString("/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/c")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = p.IsJustAKey()
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	equivalent := [][]string{
+		{
+			"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/c",
+			"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/./b/../c",
+			"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/c",
+		},
+		{
+			"/ipns/example.com/b",
+			"/ipns/example.com/a/../b",
+		},
+		{
+			"a/b",
+			"a/./b/",
+		},
+	}
+	different := []string{
+		"/ipfs/a/b",
+		"a",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+		"/ipld/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/c",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipns/example.com/c",
+	}
+
+	keys := map[string]string{}
+	for _, paths := range equivalent {
+		key := FromString(paths[0]).HashKey()
+		if len(key) != 64 {
+			t.Fatalf("expected HashKey(%s) to be 64 hex digits, not %q", paths[0], key)
+		}
+		for _, p := range paths[1:] {
+			if k := FromString(p).HashKey(); k != key {
+				t.Fatalf("expected HashKey(%s) to be the key of %s, %s, not %s", p, paths[0], key, k)
+			}
+		}
+		keys[key] = paths[0]
+	}
+	for _, p := range different {
+		key := FromString(p).HashKey()
+		if other, ok := keys[key]; ok {
+			t.Fatalf("expected HashKey(%s) to differ from the key of %s", p, other)
+		}
+		keys[key] = p
+	}
+
+	// equal paths share the same key
+	for _, c := range equalCases {
+		if equal := FromString(c.a).HashKey() == FromString(c.b).HashKey(); equal != c.equal {
+			t.Fatalf("expected HashKey(%s) == HashKey(%s) to be %v", c.a, c.b, c.equal)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type budgetKey struct{}
+
+// budget is the number of bytes left to fetch, shared by every operation
+// given a context derived from the one it is attached to.
+type budget struct {
+	left int64
+}
+
+// ContextWithBudget returns a copy of ctx carrying a budget of n bytes, which
+// every fetch made by a resolver given ctx (or a context derived from it)
+// draws from, possibly across several resolutions: once the blocks fetched
+// add up to more than n bytes, fetching fails with ErrResolveBudgetExceeded.
+// Block sizes are measured as in ResolveStats, and the shards of sharded
+// directories are drawn from the budget as WithMaxResolveBytes counts them.
+// Other operations may draw from the same budget with SpendBudget.
+func ContextWithBudget(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, budgetKey{}, &budget{left: int64(n)})
+}
+
+// SpendBudget draws n bytes from the budget attached to ctx with
+// ContextWithBudget, failing with ErrResolveBudgetExceeded if the budget is
+// exceeded. It is a no-op if ctx carries no budget.
+func SpendBudget(ctx context.Context, n int) error {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt64(&b.left, -int64(n)) < 0 {
+		return ErrResolveBudgetExceeded
+	}
+	return nil
+}
+
+// hasBudget reports whether ctx carries a budget.
+func hasBudget(ctx context.Context) bool {
+	_, ok := ctx.Value(budgetKey{}).(*budget)
+	return ok
+}
@@ -0,0 +1,54 @@
+package resolver_test
+
+import (
+	"context"
+	"testing"
+
+	merkledag "github.com/ipfs/go-merkledag"
+	dagmock "github.com/ipfs/go-merkledag/test"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextWithBudget(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	total := len(a.RawData()) + len(b.RawData()) + len(c.RawData())
+
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	// one resolution fits the budget, but two do not
+	budgetCtx := resolver.ContextWithBudget(ctx, total+1)
+	_, _, err = r.ResolvePath(budgetCtx, p)
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(budgetCtx, p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+	// once exhausted, the budget stays so
+	_, _, err = r.ResolvePath(budgetCtx, path.FromCid(c.Cid()))
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+
+	// the budget is shared with other operations and derived contexts
+	budgetCtx = resolver.ContextWithBudget(ctx, total)
+	require.NoError(t, resolver.SpendBudget(budgetCtx, 1))
+	derived, cancel := context.WithCancel(budgetCtx)
+	defer cancel()
+	_, _, err = r.ResolvePath(derived, p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+
+	// without a budget, nothing is drawn
+	require.NoError(t, resolver.SpendBudget(ctx, total))
+	_, _, err = r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+}
@@ -0,0 +1,177 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-fetcher"
+	path "github.com/ipfs/go-path"
+)
+
+// CachingResolver is a Resolver remembering the results of ResolveToLastNode
+// for /ipfs/ paths. As those paths are content-addressed, they always resolve
+// to the same result. Other paths, such as /ipns/ paths, are never cached.
+type CachingResolver struct {
+	*Resolver
+
+	cache *lru.Cache
+}
+
+type lastNode struct {
+	c    cid.Cid
+	rest []string
+}
+
+// NewCachingResolver constructs a resolver caching the results of
+// ResolveToLastNode in an LRU cache holding up to size paths, and resolving
+// everything else with inner.
+func NewCachingResolver(inner *Resolver, size int) (*CachingResolver, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &CachingResolver{Resolver: inner, cache: cache}, nil
+}
+
+// ResolveToLastNode is like Resolver.ResolveToLastNode, but /ipfs/ paths are
+// only resolved the first time they are seen.
+func (r *CachingResolver) ResolveToLastNode(ctx context.Context, fpath path.Path) (cid.Cid, []string, error) {
+	key, ok := cacheKey(fpath)
+	if !ok {
+		return r.Resolver.ResolveToLastNode(ctx, fpath)
+	}
+
+	if v, ok := r.cache.Get(key); ok {
+		res := v.(lastNode)
+		return res.c, append([]string(nil), res.rest...), nil
+	}
+
+	c, rest, err := r.Resolver.ResolveToLastNode(ctx, fpath)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	r.cache.Add(key, lastNode{c: c, rest: append([]string(nil), rest...)})
+	return c, rest, nil
+}
+
+// cacheKey returns the normalized form of fpath, if it is an /ipfs/ path.
+func cacheKey(fpath path.Path) (string, bool) {
+	normalized, err := fpath.Normalize()
+	if err != nil {
+		return "", false
+	}
+	if ns, _ := normalized.Namespace(); ns != "ipfs" {
+		return "", false
+	}
+	return "/" + strings.Join(normalized.Segments(), "/"), true
+}
+
+// SubtreeCachingResolver is a Resolver remembering the results of
+// ResolveToLastNode for /ipfs/ paths by the cid of every block crossed and the
+// segments left to resolve from it. As blocks are content-addressed, paths
+// reaching the same block share the results cached for everything below it,
+// whatever their root. Other paths, such as /ipns/ paths, are never cached.
+type SubtreeCachingResolver struct {
+	*Resolver
+
+	cache *lru.Cache
+}
+
+type subtreeKey struct {
+	root cid.Cid
+	rest string
+}
+
+func newSubtreeKey(root cid.Cid, segments []string) subtreeKey {
+	// segments may contain slashes when they are escaped
+	return subtreeKey{root: root, rest: strings.Join(segments, "\x00")}
+}
+
+// errSubtreeCached stops a walk reaching a block whose subtree is cached.
+var errSubtreeCached = errors.New("subtree is cached")
+
+// NewSubtreeCachingResolver constructs a resolver caching the results of
+// ResolveToLastNode in an LRU cache holding up to size subtrees, and resolving
+// everything else with inner. Paths are not cached when inner follows
+// symlinks, as those can lead outside of /ipfs/, nor when their resolution
+// is limited with WithMaxDepth, WithMaxResolveBytes or ContextWithBudget, as
+// a cached subtree would not count towards those limits.
+func NewSubtreeCachingResolver(inner *Resolver, size int) (*SubtreeCachingResolver, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &SubtreeCachingResolver{Resolver: inner, cache: cache}, nil
+}
+
+// ResolveToLastNode is like Resolver.ResolveToLastNode, but walking /ipfs/
+// paths stops at the first block crossed whose subtree was resolved before.
+func (r *SubtreeCachingResolver) ResolveToLastNode(ctx context.Context, fpath path.Path) (cid.Cid, []string, error) {
+	key, ok := cacheKey(fpath)
+	if !ok || r.followSymlinks || r.maxDepth > 0 || r.maxBytes > 0 || hasBudget(ctx) {
+		return r.Resolver.ResolveToLastNode(ctx, fpath)
+	}
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	c, p, err := r.splitPath(path.Path(key))
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	if len(p) == 0 {
+		return c, nil, nil
+	}
+	if res, ok := r.cached(newSubtreeKey(c, p)); ok {
+		return res.c, append([]string{}, res.rest...), nil
+	}
+
+	// resolve the path, remembering every block crossed
+	var subtrees []subtreeKey
+	var hit lastNode
+	visited := 0
+	rc, rest, err := r.resolveLast(ctx, fpath, c, p, nil, func(res fetcher.FetchResult, blk cid.Cid, newBlock bool) error {
+		i := visited
+		visited++
+		if !newBlock {
+			return nil
+		}
+		key := newSubtreeKey(blk, p[i:])
+		if i > 0 {
+			if cached, ok := r.cached(key); ok {
+				hit = cached
+				return errSubtreeCached
+			}
+		}
+		subtrees = append(subtrees, key)
+		return nil
+	}, nil)
+	if errors.Is(err, errSubtreeCached) {
+		r.add(subtrees, hit)
+		return hit.c, append([]string{}, hit.rest...), nil
+	} else if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	res := lastNode{c: rc, rest: rest}
+	r.add(subtrees, res)
+	return res.c, append([]string{}, res.rest...), nil
+}
+
+func (r *SubtreeCachingResolver) cached(key subtreeKey) (lastNode, bool) {
+	v, ok := r.cache.Get(key)
+	if !ok {
+		return lastNode{}, false
+	}
+	return v.(lastNode), true
+}
+
+// add caches res as the result of resolving every subtree.
+func (r *SubtreeCachingResolver) add(subtrees []subtreeKey, res lastNode) {
+	res.rest = append([]string{}, res.rest...)
+	for _, key := range subtrees {
+		r.cache.Add(key, res)
+	}
+}
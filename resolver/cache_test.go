@@ -0,0 +1,189 @@
+package resolver_test
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	merkledag "github.com/ipfs/go-merkledag"
+	dagmock "github.com/ipfs/go-merkledag/test"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingResolver(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r, err := resolver.NewCachingResolver(resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)), 16)
+	require.NoError(t, err)
+
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	rCid, rest, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, c.Cid(), rCid)
+	assert.Empty(t, rest)
+	gets := bstore.Gets()
+	require.NotZero(t, gets)
+
+	// the same path, however written, is served from the cache
+	for _, p := range []string{
+		p.String(),
+		p.String() + "/",
+		a.Cid().String() + "/child/./grandchild",
+	} {
+		rCid, rest, err = r.ResolveToLastNode(ctx, path.FromString(p))
+		require.NoError(t, err)
+		assert.Equal(t, c.Cid(), rCid)
+		assert.Empty(t, rest)
+		assert.Equal(t, gets, bstore.Gets(), "path %s was fetched again", p)
+	}
+
+	// ipns paths are never served from the cache, but left to the inner
+	// resolver, which cannot resolve them
+	p, err = path.FromSegments("/ipns/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	_, _, innerErr := r.Resolver.ResolveToLastNode(ctx, p)
+	require.Error(t, innerErr)
+	for i := 0; i < 2; i++ {
+		_, _, err = r.ResolveToLastNode(ctx, p)
+		assert.Equal(t, innerErr, err)
+	}
+}
+
+func TestSubtreeCachingResolver(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+
+	// a/child and d/other both lead to the subtree rooted at b
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	d := randNode()
+	e := randNode()
+	require.NoError(t, c.AddNodeLink("y", e))
+	require.NoError(t, b.AddNodeLink("x", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	require.NoError(t, d.AddNodeLink("other", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c, d, e} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	inner := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	r, err := resolver.NewSubtreeCachingResolver(inner, 16)
+	require.NoError(t, err)
+
+	resolve := func(r interface {
+		ResolveToLastNode(context.Context, path.Path) (cid.Cid, []string, error)
+	}, segs ...string) int {
+		p, err := path.FromSegments("/ipfs/", segs...)
+		require.NoError(t, err)
+		gets := bstore.Gets()
+		rCid, rest, err := r.ResolveToLastNode(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, e.Cid(), rCid)
+		assert.Empty(t, rest)
+		return bstore.Gets() - gets
+	}
+
+	require.NotZero(t, resolve(r, a.Cid().String(), "child", "x", "y"))
+
+	// the subtree of b is cached, whatever the root it is reached from
+	assert.Zero(t, resolve(r, b.Cid().String(), "x", "y"))
+	assert.Zero(t, resolve(r, c.Cid().String(), "y"))
+	uncached := resolve(inner, d.Cid().String(), "other", "x", "y")
+	assert.Less(t, resolve(r, d.Cid().String(), "other", "x", "y"), uncached)
+	assert.Zero(t, resolve(r, d.Cid().String(), "other", "x", "y"))
+
+	// ipns paths are never served from the cache, but left to the inner
+	// resolver, which cannot resolve them
+	p, err := path.FromSegments("/ipns/", b.Cid().String(), "x", "y")
+	require.NoError(t, err)
+	_, _, innerErr := inner.ResolveToLastNode(ctx, p)
+	require.Error(t, innerErr)
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	assert.Equal(t, innerErr, err)
+}
+
+func TestSubtreeCachingResolverMatchesInner(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, sub.AddNodeLink("file", file))
+	subMeta := unixfsNode(t, data.Data_Metadata, nil)
+	require.NoError(t, subMeta.AddNodeLink("", sub))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("sub", subMeta))
+	for _, n := range []format.Node{file, sub, subMeta, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	inner := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	r, err := resolver.NewSubtreeCachingResolver(inner, 16)
+	require.NoError(t, err)
+
+	// the cached results are those of the inner resolver, through Metadata
+	// nodes and to missing links alike, the second time as the first
+	for _, segs := range [][]string{
+		{dir.Cid().String(), "sub", "file"},
+		{dir.Cid().String(), "sub"},
+		{subMeta.Cid().String(), "file"},
+		{dir.Cid().String(), "sub", "missing"},
+		{dir.Cid().String(), "missing", "file"},
+	} {
+		p, err := path.FromSegments("/ipfs/", segs...)
+		require.NoError(t, err)
+		expectedCid, expectedRest, expectedErr := inner.ResolveToLastNode(ctx, p)
+		for i := 0; i < 2; i++ {
+			rCid, rest, err := r.ResolveToLastNode(ctx, p)
+			assert.Equal(t, expectedErr, err, p)
+			assert.Equal(t, expectedCid, rCid, p)
+			assert.Equal(t, expectedRest, rest, p)
+		}
+	}
+}
+
+func TestSubtreeCachingResolverLimits(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	root := addChain(ctx, t, bsrv, 8)
+	fetcherFactory := newUnixFSFetcherFactory(bsrv)
+
+	// the subtree 3 links below root, through the 5 links left
+	mid, _, err := resolver.NewBasicResolver(fetcherFactory).ResolveToLastNode(ctx, chainPath(t, root, 3))
+	require.NoError(t, err)
+	suffix, err := path.FromSegments("/ipfs/", mid.String(), "child", "child", "child", "child", "child")
+	require.NoError(t, err)
+
+	r, err := resolver.NewSubtreeCachingResolver(resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxDepth(5)), 16)
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNode(ctx, chainPath(t, root, 8))
+	require.ErrorIs(t, err, resolver.ErrPathTooDeep)
+	_, _, err = r.ResolveToLastNode(ctx, suffix)
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNode(ctx, chainPath(t, root, 8))
+	require.ErrorIs(t, err, resolver.ErrPathTooDeep)
+
+	r, err = resolver.NewSubtreeCachingResolver(resolver.NewBasicResolver(fetcherFactory), 16)
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNode(ctx, chainPath(t, root, 8))
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNode(resolver.ContextWithBudget(ctx, 1), chainPath(t, root, 8))
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+}
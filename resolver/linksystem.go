@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/ipfs/go-fetcher"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// NewResolverFromLinkSystem constructs a resolver loading blocks with lsys
+// rather than with a fetcher factory: blocks are read from its storage and
+// decoded, hashed and reified as configured in lsys. dag-pb blocks are loaded
+// as dagpb.Type.PBNode, as the UnixFS reifier expects, and every other block
+// with basicnode.Prototype.Any.
+func NewResolverFromLinkSystem(lsys ipld.LinkSystem, opts ...Option) *Resolver {
+	return NewBasicResolver(linkSystemFetcher{lsys: lsys}, opts...)
+}
+
+// linkSystemFetcher is both a fetcher factory and the fetcher of its sessions,
+// as loading with a LinkSystem requires no session state.
+type linkSystemFetcher struct {
+	lsys ipld.LinkSystem
+}
+
+var _ fetcher.Factory = linkSystemFetcher{}
+var _ fetcher.Fetcher = linkSystemFetcher{}
+
+func (f linkSystemFetcher) NewSession(ctx context.Context) fetcher.Fetcher {
+	return f
+}
+
+// WithReifier returns a fetcher factory loading blocks with the same
+// LinkSystem, but with nr as its NodeReifier.
+func (f linkSystemFetcher) WithReifier(nr ipld.NodeReifier) fetcher.Factory {
+	f.lsys.NodeReifier = nr
+	return f
+}
+
+func (f linkSystemFetcher) BlockOfType(ctx context.Context, lnk ipld.Link, proto ipld.NodePrototype) (ipld.Node, error) {
+	return f.lsys.Load(ipld.LinkContext{Ctx: ctx}, lnk, proto)
+}
+
+func (f linkSystemFetcher) NodeMatching(ctx context.Context, nd ipld.Node, match ipld.Node, cb fetcher.FetchCallback) error {
+	return f.nodeMatching(ctx, traversal.Progress{}, nd, match, cb)
+}
+
+func (f linkSystemFetcher) BlockMatchingOfType(ctx context.Context, root ipld.Link, match ipld.Node, _ ipld.NodePrototype, cb fetcher.FetchCallback) error {
+	proto, err := f.PrototypeFromLink(root)
+	if err != nil {
+		return err
+	}
+	nd, err := f.BlockOfType(ctx, root, proto)
+	if err != nil {
+		return err
+	}
+	var progress traversal.Progress
+	progress.LastBlock.Link = root
+	return f.nodeMatching(ctx, progress, nd, match, cb)
+}
+
+func (f linkSystemFetcher) nodeMatching(ctx context.Context, progress traversal.Progress, nd ipld.Node, match ipld.Node, cb fetcher.FetchCallback) error {
+	sel, err := selector.ParseSelector(match)
+	if err != nil {
+		return err
+	}
+	progress.Cfg = &traversal.Config{
+		Ctx:                            ctx,
+		LinkSystem:                     f.lsys,
+		LinkTargetNodePrototypeChooser: linkSystemPrototypeChooser,
+	}
+	return progress.WalkMatching(nd, sel, func(prog traversal.Progress, n ipld.Node) error {
+		return cb(fetcher.FetchResult{
+			Node:          n,
+			Path:          prog.Path,
+			LastBlockPath: prog.LastBlock.Path,
+			LastBlockLink: prog.LastBlock.Link,
+		})
+	})
+}
+
+func (f linkSystemFetcher) PrototypeFromLink(lnk ipld.Link) (ipld.NodePrototype, error) {
+	return linkSystemPrototypeChooser(lnk, ipld.LinkContext{})
+}
+
+var linkSystemPrototypeChooser = dagpb.AddSupportToChooser(func(lnk ipld.Link, lnkCtx ipld.LinkContext) (ipld.NodePrototype, error) {
+	if tlnkNd, ok := lnkCtx.LinkNode.(schema.TypedLinkNode); ok {
+		return tlnkNd.LinkTargetNodePrototype(), nil
+	}
+	return basicnode.Prototype.Any, nil
+})
@@ -0,0 +1,79 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverFromLinkSystem(t *testing.T) {
+	ctx := context.Background()
+	store := &storage.Memory{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = store.OpenRead
+	lsys.StorageWriteOpener = store.OpenWrite
+
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}}
+	add := func(fn func(ipld.MapAssembler)) cid.Cid {
+		nd, err := qp.BuildMap(basicnode.Prototype.Any, -1, fn)
+		require.NoError(t, err)
+		lnk, err := lsys.Store(ipld.LinkContext{}, lp, nd)
+		require.NoError(t, err)
+		return lnk.(cidlink.Link).Cid
+	}
+	leaf := add(func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "value", qp.String("hello"))
+	})
+	mid := add(func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "next", qp.Link(cidlink.Link{Cid: leaf}))
+	})
+	root := add(func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "a", qp.Map(-1, func(ma ipld.MapAssembler) {
+			qp.MapEntry(ma, "b", qp.Link(cidlink.Link{Cid: mid}))
+		}))
+	})
+
+	r := resolver.NewResolverFromLinkSystem(lsys)
+	p, err := path.FromSegments("/ipfs/", root.String(), "a", "b", "next", "value")
+	require.NoError(t, err)
+
+	nd, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, leaf, lnk.(cidlink.Link).Cid)
+	s, err := nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", s)
+
+	rCid, remainder, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, leaf, rCid)
+	assert.Equal(t, []string{"value"}, remainder)
+
+	// the selector-based resolution walks the link system too
+	nodes, err := r.ResolvePathComponents(ctx, p)
+	require.NoError(t, err)
+	assert.Len(t, nodes, 5)
+
+	p, err = path.FromSegments("/ipfs/", root.String(), "a", "missing")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	var noLink resolver.ErrNoLink
+	assert.True(t, errors.As(err, &noLink), "expected ErrNoLink, got %v", err)
+}
@@ -2,24 +2,46 @@
 package resolver
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	gopath "path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ipld/go-ipld-prime/schema"
 
 	path "github.com/ipfs/go-path"
 
+	"github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	"github.com/ipfs/go-fetcher"
 	fetcherhelpers "github.com/ipfs/go-fetcher/helpers"
+	bsfetcher "github.com/ipfs/go-fetcher/impl/blockservice"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	format "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/go-unixfsnode/data"
+	databuilder "github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/multiformats/go-multihash"
+
+	// register the codecs of the blocks commonly found along paths, besides
+	// dag-pb, so that they can be traversed alike
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
 )
 
 var log = logging.Logger("pathresolv")
@@ -29,7 +51,55 @@ var log = logging.Logger("pathresolv")
 var ErrNoComponents = errors.New(
 	"path must contain at least one component")
 
-// ErrNoLink is returned when a link is not found in a path
+// ErrPathTooDeep is returned when resolving a path would follow more links
+// than the maximum depth configured with WithMaxDepth.
+var ErrPathTooDeep = errors.New("path exceeds maximum resolution depth")
+
+// ErrCyclicLink is returned when resolving a path would follow a link back to
+// a block already traversed by the same resolution. Well-formed DAGs cannot
+// contain cycles, so this only happens with malformed or adversarial data.
+var ErrCyclicLink = errors.New("path resolution followed a cyclic link")
+
+// ErrNotADirectory is returned when a path that must resolve to a UnixFS
+// directory, such as one given to ResolveEntries, does not.
+var ErrNotADirectory = errors.New("not a directory")
+
+// ErrTooManyEntries is returned when listing a directory with more entries
+// than configured with WithMaxEntries.
+var ErrTooManyEntries = errors.New("directory has too many entries")
+
+// ErrNotAFile is returned by ResolveFileBytes and ResolveFileReader when the
+// path does not resolve to a UnixFS file or a raw block.
+var ErrNotAFile = errors.New("not a file")
+
+// ErrFileTooLarge is returned by ResolveFileBytes when the file is larger than
+// allowed.
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrResolveBudgetExceeded is returned when the blocks traversed by a single
+// resolution add up to more bytes than configured with WithMaxResolveBytes, or
+// when the blocks fetched with a context add up to more bytes than its budget
+// (see ContextWithBudget).
+var ErrResolveBudgetExceeded = errors.New("resolution exceeds byte budget")
+
+// ErrTooManySymlinks is returned when following symlinks (see
+// WithFollowSymlinks) takes more than a fixed number of hops, which usually
+// indicates a symlink loop.
+var ErrTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// ErrIndexOutOfRange is returned when a numeric path segment indexes past the
+// end of a list node.
+var ErrIndexOutOfRange = errors.New("list index out of range")
+
+// ErrHopTimeout is returned when fetching a single block takes longer than
+// configured with WithPerHopTimeout. It wraps context.DeadlineExceeded.
+var ErrHopTimeout = fmt.Errorf("block fetch timed out: %w", context.DeadlineExceeded)
+
+// maxSymlinks is the number of symlinks a single resolution may follow.
+const maxSymlinks = 32
+
+// ErrNoLink is returned when a link is not found in a path. Node is undefined
+// when the block holding the missing link is not known.
 type ErrNoLink struct {
 	Name string
 	Node cid.Cid
@@ -38,67 +108,613 @@ type ErrNoLink struct {
 // Error implements the Error interface for ErrNoLink with a useful
 // human readable message.
 func (e ErrNoLink) Error() string {
+	if !e.Node.Defined() {
+		return fmt.Sprintf("no link named %q", e.Name)
+	}
 	return fmt.Sprintf("no link named %q under %s", e.Name, e.Node.String())
 }
 
+// ErrFetchFailed is returned when a block could not be fetched for another
+// reason than it not being found, such as a network failure or a per-hop
+// timeout. Unlike ErrNoLink and not found errors, which are bound to happen
+// again, resolving the same path may then succeed if retried. Blocks that
+// fail to load while looking a path segment up, such as the shards of a
+// sharded directory, are reported under the block the lookup was made in.
+type ErrFetchFailed struct {
+	Cid cid.Cid
+	Err error
+}
+
+func (e ErrFetchFailed) Error() string {
+	return fmt.Sprintf("failed to fetch %s: %s", e.Cid, e.Err)
+}
+
+// Unwrap returns the error returned by the fetcher.
+func (e ErrFetchFailed) Unwrap() error {
+	return e.Err
+}
+
 // Resolver provides path resolution to IPFS
 // It references a FetcherFactory, which is uses to resolve nodes.
+// Paths in the /ipld/ namespace are resolved on the data model of the blocks,
+// such as the Links and Data fields of dag-pb blocks: nodes are not reified
+// (neither with the NodeReifier of the fetcher factories nor with
+// WithNodeReifier), and neither symlinks nor UnixFS Metadata nodes are
+// followed. Otherwise, a UnixFS Metadata node is stepped through to the node it
+// wraps, as if the path linked to the wrapped node directly (except by
+// ResolveToLastNode, which does not fetch the block a path ends at).
 // TODO: now that this is more modular, try to unify this code with the
-//       the resolvers in namesys
+// resolvers in namesys
 type Resolver struct {
 	FetcherFactory fetcher.Factory
+
+	fallbackFactory  fetcher.Factory
+	maxDepth         int
+	maxBytes         int
+	maxEntries       int
+	followSymlinks   bool
+	tracer           Tracer
+	transcript       *transcript
+	caseInsensitive  bool
+	linkName         func(string) string
+	onCodec          func(cid.Cid, uint64)
+	escapedSegments  bool
+	rawLeavesAsFiles bool
+	hopTimeout       time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	maxFetches       int
+	reifier          ipld.NodeReifier
+	rawNodes         bool
+	trailingSlashDir bool
+	percentDecoded   bool
+
+	// batchLoader, if set, is shared by the resolutions of a batch, and
+	// rawBatchLoader by those of its /ipld/ paths, as their nodes are not
+	// reified
+	batchLoader    *blockLoader
+	rawBatchLoader *blockLoader
+
+	// stopAtSymlinks is set on the copy followingSymlinks resolves with, so
+	// that walks end with an errSymlink at the first symlink they reach
+	stopAtSymlinks bool
+}
+
+// Option configures a Resolver created with NewBasicResolver.
+type Option func(*Resolver)
+
+// WithMaxDepth bounds the number of links a single resolution may follow by
+// fetching the linked block. Resolving a deeper path fails with
+// ErrPathTooDeep. A depth of 0 (the default) means unlimited.
+func WithMaxDepth(depth int) Option {
+	return func(r *Resolver) {
+		r.maxDepth = depth
+	}
+}
+
+// WithMaxResolveBytes bounds the total size of the blocks a single resolution
+// may traverse; once it is exceeded, resolution fails with
+// ErrResolveBudgetExceeded. Block sizes are measured as in ResolveStats, but
+// the shards of sharded directories below their root shards count towards the
+// limit too, when loaded by the LinkSystem of a fetcher factory whose node
+// reifier the resolver knows. A limit of 0 (the default) means unlimited.
+func WithMaxResolveBytes(n int) Option {
+	return func(r *Resolver) {
+		r.maxBytes = n
+	}
+}
+
+// WithMaxEntries bounds the number of entries of a directory listed by
+// ResolveEntries (and Glob); listing a larger directory fails with
+// ErrTooManyEntries as soon as the limit is exceeded, before the rest of the
+// directory is fetched. A limit of 0 (the default) means unlimited.
+func WithMaxEntries(n int) Option {
+	return func(r *Resolver) {
+		r.maxEntries = n
+	}
+}
+
+// WithFollowSymlinks makes the resolver follow UnixFS symlinks found along a
+// path. A relative symlink target is resolved against the directory holding the
+// symlink, and an absolute target must be an /ipfs/ path. Resolution fails with
+// ErrTooManySymlinks when too many symlinks are followed. Symlinks are found as
+// the path is walked, so ResolveToLastNode and the methods built like it fetch
+// the block the path resolves to, to tell whether it is a symlink.
+func WithFollowSymlinks() Option {
+	return func(r *Resolver) {
+		r.followSymlinks = true
+	}
+}
+
+// WithCaseInsensitiveSegments makes the resolver retry a path segment that
+// does not name a field of a map node (such as a UnixFS directory) with a
+// case-insensitive comparison against its field names. An exact match is
+// always preferred; among several case-insensitive matches, the first in
+// byte-wise order is chosen. Retrying enumerates every field of the node,
+// including all the shards of a sharded directory.
+func WithCaseInsensitiveSegments() Option {
+	return func(r *Resolver) {
+		r.caseInsensitive = true
+	}
+}
+
+// WithLinkNameTransformer makes the resolver look up fn(segment) rather than
+// segment for every path segment following the root, such as to match link
+// names stored with a prefix or in some encoding. The segments reported by the
+// resolver (in remainders and errors) are left untransformed. ResolveLinks,
+// which is given nodes and names rather than a path, does not transform names.
+func WithLinkNameTransformer(fn func(segment string) string) Option {
+	return func(r *Resolver) {
+		r.linkName = fn
+	}
+}
+
+// WithOnCodec makes the resolver call fn with the cid and codec of every block
+// it fetches while resolving a path, starting with its root, such as to detect
+// paths crossing blocks of a deprecated codec. fn cannot affect resolution. As
+// ResolveToLastNode does not fetch the block a path ends at, that block is not
+// reported by ResolveToLastNode.
+func WithOnCodec(fn func(c cid.Cid, codec uint64)) Option {
+	return func(r *Resolver) {
+		r.onCodec = fn
+	}
+}
+
+// WithEscapedSegments makes the resolver interpret backslashes in paths as
+// escape characters, so that map keys containing a slash (such as DAG-CBOR or
+// DAG-JSON keys) can be traversed: within a path segment, "\/" stands for a
+// literal "/" that does not delimit segments, and "\\" for a literal
+// backslash. A backslash followed by any other character is dropped. For
+// instance, /ipfs/<cid>/a\/b/c resolves the key "c" within the key "a/b".
+// Segments returned by the resolver, such as the remainder returned by
+// ResolveToLastNode, are unescaped.
+func WithEscapedSegments() Option {
+	return func(r *Resolver) {
+		r.escapedSegments = true
+	}
+}
+
+// WithRawLeavesAsFiles makes ResolvePath return the raw-codec leaf a path may
+// resolve to as a dag-pb node holding a UnixFS file with the leaf's bytes, like
+// a dag-pb file leaf, so that callers can read data from both kinds of leaves
+// uniformly (through the node's Data field). The returned link is unchanged.
+func WithRawLeavesAsFiles() Option {
+	return func(r *Resolver) {
+		r.rawLeavesAsFiles = true
+	}
+}
+
+// WithPerHopTimeout bounds the time spent fetching each block of a resolution,
+// failing it with ErrHopTimeout when a single fetch takes longer than d. The
+// context passed to the resolver still bounds the resolution as a whole. Each
+// block is then fetched with a fetcher session of its own, which also loads
+// the blocks its node is spread over, such as the shards of a sharded
+// directory, within the same timeout. A timeout of 0 (the default) means no
+// per-hop timeout.
+func WithPerHopTimeout(d time.Duration) Option {
+	return func(r *Resolver) {
+		r.hopTimeout = d
+	}
+}
+
+// WithRetry makes the resolver retry fetching a block up to attempts times
+// when the fetch fails with an ErrFetchFailed, such as a transport error or a
+// per-hop timeout, waiting backoff before the first retry and twice as long
+// before each of the next ones. Blocks and links that are missing are not
+// retried, as they would be missing again.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(r *Resolver) {
+		r.retries = attempts
+		r.retryBackoff = backoff
+	}
+}
+
+// WithMaxConcurrentFetches bounds the number of blocks fetched at once to n,
+// for bandwidth control; further fetches wait for one of those to complete.
+// A resolution fetches one block at a time, so the bound is shared by the
+// resolutions of a ResolveBatch, which share their fetcher sessions, and by
+// the blocks loaded by the nodes a resolution reaches, such as the shards of
+// sharded directories (for fetcher factories whose node reifier the resolver
+// knows, see ResolveStats.Bytes). A bound of 0 (the default) means unlimited.
+func WithMaxConcurrentFetches(n int) Option {
+	return func(r *Resolver) {
+		r.maxFetches = n
+	}
+}
+
+// WithNodeReifier makes the resolver fetch every block with fn as the node
+// reifier, in place of the NodeReifier configured in the fetcher factories:
+// nodes are reified by fn only. fn is installed with the WithReifier method of
+// the factories (such as bsfetcher.FetcherConfig.WithReifier), so factories
+// without such a method are used unchanged.
+func WithNodeReifier(fn ipld.NodeReifier) Option {
+	return func(r *Resolver) {
+		r.reifier = fn
+	}
+}
+
+// WithTrailingSlashDirectories makes a trailing slash on a path, as in
+// /ipfs/<cid>/dir/, mean that the path must resolve to a UnixFS directory:
+// ResolvePath (and the methods built on it) and ResolveToLastNodeKind fail
+// with ErrNotADirectory if it does not. ResolveToLastNode, which does not fetch
+// the block a path ends at, ignores trailing slashes.
+func WithTrailingSlashDirectories() Option {
+	return func(r *Resolver) {
+		r.trailingSlashDir = true
+	}
+}
+
+// WithoutReification makes the resolver leave every node as decoded by its
+// codec, whatever the namespace of the path, so that dag-pb data is traversed
+// as plain PBNode maps rather than as UnixFS directories. As with
+// WithNodeReifier, factories without a WithReifier method are used unchanged.
+func WithoutReification() Option {
+	return func(r *Resolver) {
+		r.reifier = rawReifier
+		r.rawNodes = true
+	}
+}
+
+// WithPercentDecodedSegments makes the resolver percent-decode every path
+// segment following the root before resolving it, as for segments of
+// URL-encoded gateway paths. Unlike with path.ParsePathDecoded, an encoded
+// slash (%2F) is allowed, and is part of the segment. With
+// WithEscapedSegments, segments are unescaped before being decoded. The
+// segments as written in the path are available as ResolveStep.RawName.
+func WithPercentDecodedSegments() Option {
+	return func(r *Resolver) {
+		r.percentDecoded = true
+	}
+}
+
+// Tracer starts a span around every block fetched while resolving a path.
+type Tracer interface {
+	// StartSpan starts a span with the given name. The returned context is
+	// used for the fetch.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+// WithTracer makes the resolver start a span named "resolver.fetch" with t
+// around every block fetch. The span records the path segment naming the
+// link (empty for the root of the path) as "segment", the cid fetched as
+// "cid", and, if the fetch failed, the error as "error".
+func WithTracer(t Tracer) Option {
+	return func(r *Resolver) {
+		r.tracer = t
+	}
+}
+
+// TranscriptEntry records a block fetched by a resolution, as recorded by
+// WithTranscript.
+type TranscriptEntry struct {
+	// Segment is the path segment naming the link to the block, empty for
+	// the root of the path.
+	Segment string
+	Cid     cid.Cid
+	// Err is the error the fetch failed with, if any.
+	Err error
+}
+
+type transcript struct {
+	mu      sync.Mutex
+	entries *[]TranscriptEntry
+}
+
+// WithTranscript makes the resolver append an entry to entries for every block
+// fetched, in the order fetches complete, so that a failed resolution can be
+// compared with one that succeeded. entries is shared by all the resolutions,
+// and must not be read while one is running.
+func WithTranscript(entries *[]TranscriptEntry) Option {
+	return func(r *Resolver) {
+		r.transcript = &transcript{entries: entries}
+	}
 }
 
 // NewBasicResolver constructs a new basic resolver.
-func NewBasicResolver(fetcherFactory fetcher.Factory) *Resolver {
-	return &Resolver{
+func NewBasicResolver(fetcherFactory fetcher.Factory, opts ...Option) *Resolver {
+	r := &Resolver{
 		FetcherFactory: fetcherFactory,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// NewFallbackResolver constructs a resolver fetching blocks with the primary
+// fetcher factory. Every block that the primary factory cannot find is fetched
+// with the secondary factory instead; the next block is again fetched with the
+// primary factory first. When both fail, the returned error is a
+// *FallbackError.
+func NewFallbackResolver(primary, secondary fetcher.Factory, opts ...Option) *Resolver {
+	r := NewBasicResolver(primary, opts...)
+	r.fallbackFactory = secondary
+	return r
+}
+
+// Close releases the resources held by the resolver's fetcher factories, by
+// closing those implementing io.Closer. It is a no-op for stateless factories,
+// which is the case of the blockservice fetcher. The error of the first
+// factory failing to close is returned.
+func (r *Resolver) Close() error {
+	var err error
+	for _, factory := range []fetcher.Factory{r.FetcherFactory, r.fallbackFactory} {
+		if c, ok := factory.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// FallbackError is returned by resolvers created with NewFallbackResolver when
+// a block could be fetched with neither fetcher factory.
+type FallbackError struct {
+	Primary   error
+	Secondary error
+}
+
+// Error implements the Error interface for FallbackError.
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("primary fetcher: %s; secondary fetcher: %s", e.Primary, e.Secondary)
+}
+
+// Is reports whether either underlying error matches target.
+func (e *FallbackError) Is(target error) bool {
+	return errors.Is(e.Primary, target) || errors.Is(e.Secondary, target)
+}
+
+// As finds the first of the underlying errors that matches target.
+func (e *FallbackError) As(target interface{}) bool {
+	return errors.As(e.Primary, target) || errors.As(e.Secondary, target)
+}
+
+// statsKey is the context key of the ResolveStats of a resolution.
+type statsKey struct{}
+
+// ResolveStats describes the work done by a single resolution.
+type ResolveStats struct {
+	// BlocksFetched is the number of blocks the resolution traversed.
+	BlocksFetched int
+	// Bytes is the total size of the raw data of the traversed blocks. The
+	// raw data of blocks fetched with a fetcher factory whose node reifier
+	// the resolver cannot know (a custom factory, without WithNodeReifier)
+	// cannot be read, so these blocks are measured as encoded with their
+	// codec, which matches their raw size when they are canonically encoded.
+	Bytes int
+	// ShardHops is the number of HAMT shard nodes traversed to look entries up
+	// in sharded directories, counting the root shard of each directory: it
+	// is 0 for paths through basic directories only. Shards below the root
+	// shards are not part of BlocksFetched and Bytes, and are only counted
+	// as loaded by the LinkSystems of fetcher factories whose node reifier
+	// the resolver knows (see Bytes).
+	ShardHops int
 }
 
 // ResolveToLastNode walks the given path and returns the cid of the last block
 // referenced by the path, and the path segments to traverse from the final block boundary to the final node
 // within the block.
 func (r *Resolver) ResolveToLastNode(ctx context.Context, fpath path.Path) (cid.Cid, []string, error) {
-	c, p, err := path.SplitAbsPath(fpath)
+	return r.resolveToLastNode(ctx, fpath, nil)
+}
+
+// ResolveToLastNodeLink is like ResolveToLastNode, but returns the link to the
+// last block as an ipld.Link, as ResolvePath does. Links are currently always
+// cidlink.Links.
+func (r *Resolver) ResolveToLastNodeLink(ctx context.Context, fpath path.Path) (ipld.Link, []string, error) {
+	c, rest, err := r.resolveToLastNode(ctx, fpath, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cidlink.Link{Cid: c}, rest, nil
+}
+
+// ResolveToLastNodeWithPrototype is like ResolveToLastNode, but returns the
+// node prototype to decode the last block into, as chosen by the resolver's
+// fetcher for its codec, rather than the remainder of the path. As the
+// remainder is not returned, it fails if the path ends within the last
+// block. The block is not fetched.
+func (r *Resolver) ResolveToLastNodeWithPrototype(ctx context.Context, fpath path.Path) (cid.Cid, ipld.NodePrototype, error) {
+	c, rest, err := r.resolveToLastNode(ctx, fpath, nil)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	if len(rest) > 0 {
+		return cid.Cid{}, nil, fmt.Errorf("path %v ends within block %s, at %s", fpath, c, strings.Join(rest, "/"))
+	}
+
+	proto, err := r.newBlockLoader(ctx).session(0).PrototypeFromLink(cidlink.Link{Cid: c})
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	return c, proto, nil
+}
+
+// ResolveToLastNodeRemainderPath is like ResolveToLastNode, but returns the
+// remaining path segments as a relative path, such as "a/b", written as they
+// would be in a path given to this resolver. The remainder is the empty path
+// when the path resolves to the root of a block.
+func (r *Resolver) ResolveToLastNodeRemainderPath(ctx context.Context, fpath path.Path) (cid.Cid, path.Path, error) {
+	c, rest, err := r.resolveToLastNode(ctx, fpath, nil)
+	if err != nil {
+		return cid.Cid{}, "", err
+	}
+	return c, path.FromString(path.Join(r.escapeSegments(rest))), nil
+}
+
+// ResolveCidChain is like ResolveToLastNode, but returns the cids of all the
+// blocks crossed to reach the last one, in traversal order: the root of the
+// path first and the cid ResolveToLastNode returns last, with the UnixFS
+// Metadata nodes stepped through along the way. As with ResolveToLastNode,
+// the last block is not fetched.
+func (r *Resolver) ResolveCidChain(ctx context.Context, fpath path.Path) ([]cid.Cid, []string, error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var chain []cid.Cid
+	var rest []string
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		chain = nil
+		_, rest, err = r.resolveLast(ctx, fpath, c, p, nil, nil, func(blk cid.Cid) {
+			chain = append(chain, blk)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return chain, rest, nil
+}
+
+// BatchResult is the result of resolving one of the paths given to
+// ResolveBatch, as returned by ResolveToLastNode.
+type BatchResult struct {
+	Cid       cid.Cid
+	Remainder []string
+	Err       error
+}
+
+// ResolveBatch resolves every path like ResolveToLastNode, with up to
+// concurrency resolutions running at once (one at a time if concurrency is
+// below 1), and returns their results in the order of paths. The resolutions
+// share their fetcher sessions.
+func (r *Resolver) ResolveBatch(ctx context.Context, paths []path.Path, concurrency int) []BatchResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batch := *r
+	batch.batchLoader = r.newBlockLoader(ctx)
+	batch.rawBatchLoader = r.raw().newBlockLoader(ctx)
+	batch.rawBatchLoader.sem = batch.batchLoader.sem
+
+	results := make([]BatchResult, len(paths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				res := &results[i]
+				res.Cid, res.Remainder, res.Err = batch.ResolveToLastNode(ctx, paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// ResolveToLastNodeWithStats is like ResolveToLastNode, but also reports
+// statistics about the blocks traversed. As ResolveToLastNode does not fetch
+// the block the path resolves to, that block is not part of the statistics.
+func (r *Resolver) ResolveToLastNodeWithStats(ctx context.Context, fpath path.Path) (cid.Cid, []string, ResolveStats, error) {
+	var stats ResolveStats
+	c, rest, err := r.resolveToLastNode(ctx, fpath, &stats)
+	return c, rest, stats, err
+}
+
+func (r *Resolver) resolveToLastNode(ctx context.Context, fpath path.Path, stats *ResolveStats) (cid.Cid, []string, error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	var last cid.Cid
+	var rest []string
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		// only count the blocks of the walk that crosses no symlink
+		if stats != nil {
+			*stats = ResolveStats{}
+		}
+		last, rest, err = r.resolveLast(ctx, fpath, c, p, stats, nil, nil)
+		return err
+	})
 	if err != nil {
 		return cid.Cid{}, nil, err
 	}
+	return last, rest, nil
+}
 
+// resolveLast resolves the segments p of fpath from its root c to the last
+// block they reference, for resolveToLastNode and the methods built like it.
+// visit, if not nil, is called as by walk for every node up to the parent of
+// the node named by the last segment, and an error it returns ends the
+// resolution. onBlock, if not nil, is called with the cid of every block
+// traversed, in order: those fetched, as by walkBlocks, then the block the
+// path resolves to, which is not fetched, if another link leads to it.
+func (r *Resolver) resolveLast(ctx context.Context, fpath path.Path, c cid.Cid, p []string, stats *ResolveStats, visit func(fetcher.FetchResult, cid.Cid, bool) error, onBlock func(cid.Cid)) (cid.Cid, []string, error) {
 	if len(p) == 0 {
+		if onBlock != nil {
+			onBlock(c)
+		}
 		return c, nil, nil
 	}
 
-	// create a selector to traverse and match all path segments
-	pathSelector := pathAllSelector(p[:len(p)-1])
-
 	// create a new cancellable session
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
+	// the last segment is looked up in a node fetched by the walk
+	ctx, release := withHopContexts(ctx)
+	defer release()
 
 	// resolve node before last path segment
-	nodes, lastCid, depth, err := r.resolveNodes(ctx, c, pathSelector)
+	var parent ipld.Node
+	lastCid := cid.Undef
+	resolved, depth := 0, 0
+	err := r.walkBlocks(ctx, c, p[:len(p)-1], stats, onBlock, func(res fetcher.FetchResult, blk cid.Cid, newBlock bool) error {
+		resolved++
+		parent = res.Node
+		if newBlock {
+			depth = 0
+			lastCid = blk
+		} else {
+			depth++
+		}
+		if visit != nil {
+			return visit(res, blk, newBlock)
+		}
+		return nil
+	})
 	if err != nil {
 		return cid.Cid{}, nil, err
 	}
-
-	if len(nodes) < 1 {
-		return cid.Cid{}, nil, fmt.Errorf("path %v did not resolve to a node", fpath)
-	} else if len(nodes) < len(p) {
-		return cid.Undef, nil, ErrNoLink{Name: p[len(nodes)-1], Node: lastCid}
+	if resolved < len(p) {
+		return cid.Undef, nil, ErrNoLink{Name: p[resolved-1], Node: lastCid}
 	}
 
-	parent := nodes[len(nodes)-1]
-	lastSegment := p[len(p)-1]
-
 	// find final path segment within node
-	nd, err := parent.LookupBySegment(ipld.ParsePathSegment(lastSegment))
-	switch err.(type) {
-	case nil:
-	case schema.ErrNoSuchField:
+	lastSegment := p[len(p)-1]
+	nd, err := r.lookupWithStats(parent, lastSegment, stats)
+	if isNoSuchLink(err) {
 		return cid.Undef, nil, ErrNoLink{Name: lastSegment, Node: lastCid}
-	default:
-		return cid.Cid{}, nil, err
+	} else if err != nil {
+		return cid.Cid{}, nil, lookupError(ctx, lastCid, err)
 	}
 
 	// if last node is not a link, just return it's cid, add path to remainder and return
@@ -116,86 +732,1038 @@ func (r *Resolver) ResolveToLastNode(ctx context.Context, fpath path.Path) (cid.
 	if !ok {
 		return cid.Cid{}, nil, fmt.Errorf("path %v resolves to a link that is not a cid link: %v", fpath, lnk)
 	}
+	if r.stopAtSymlinks {
+		// the block the path resolves to must be fetched to tell whether it
+		// is a symlink to follow
+		err := r.walk(ctx, clnk.Cid, nil, nil, func(res fetcher.FetchResult, _ cid.Cid, _ bool) error {
+			if target := symlinkTarget(res.Node); target != "" {
+				return &errSymlink{at: len(p), target: target}
+			}
+			return nil
+		})
+		if err != nil {
+			return cid.Cid{}, nil, err
+		}
+	}
+	if onBlock != nil {
+		onBlock(clnk.Cid)
+	}
 
 	return clnk.Cid, []string{}, nil
 }
 
-// ResolvePath fetches the node for given path. It returns the last item
-// returned by ResolvePathComponents and the last link traversed which can be used to recover the block.
-//
-// Note: if/when the context is cancelled or expires then if a multi-block ADL node is returned then it may not be
-// possible to load certain values.
-func (r *Resolver) ResolvePath(ctx context.Context, fpath path.Path) (ipld.Node, ipld.Link, error) {
+// LeafKind is the kind of the node a path resolves to.
+type LeafKind int
+
+const (
+	// LeafOther is any node that is none of the other kinds, such as a
+	// dag-cbor node, or a node within a block.
+	LeafOther LeafKind = iota
+	// LeafFile is a UnixFS file.
+	LeafFile
+	// LeafDirectory is a UnixFS directory, sharded or not.
+	LeafDirectory
+	// LeafSymlink is a UnixFS symlink.
+	LeafSymlink
+	// LeafRaw is a raw block.
+	LeafRaw
+)
+
+func (k LeafKind) String() string {
+	switch k {
+	case LeafFile:
+		return "file"
+	case LeafDirectory:
+		return "directory"
+	case LeafSymlink:
+		return "symlink"
+	case LeafRaw:
+		return "raw"
+	default:
+		return "other"
+	}
+}
+
+// ResolveToLastNodeKind resolves the given path, and returns the cid of the
+// block holding the node it resolves to along with the kind of that node.
+// Unlike ResolveToLastNode, the block the path resolves to is fetched.
+func (r *Resolver) ResolveToLastNodeKind(ctx context.Context, fpath path.Path) (cid.Cid, LeafKind, error) {
+	r = r.forPath(fpath)
+	trailingSlash := fpath.HasTrailingSlash()
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, LeafOther, err
+	}
+
 	// validate path
 	if err := fpath.IsValid(); err != nil {
-		return nil, nil, err
+		return cid.Cid{}, LeafOther, err
 	}
 
-	c, p, err := path.SplitAbsPath(fpath)
-	if err != nil {
-		return nil, nil, err
-	}
+	var last cid.Cid
+	var kind LeafKind
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
 
-	// create a selector to traverse all path segments but only match the last
-	pathSelector := pathLeafSelector(p)
+		nodes, c, depth, err := r.resolveNodes(ctx, c, p, nil)
+		if err != nil {
+			return err
+		}
+		if len(nodes) < 1 {
+			return fmt.Errorf("path %v did not resolve to a node", fpath)
+		} else if len(nodes) < len(p)+1 {
+			return ErrNoLink{Name: p[len(nodes)-1], Node: c}
+		}
 
-	nodes, c, _, err := r.resolveNodes(ctx, c, pathSelector)
+		kind = leafKind(c, depth, nodes[len(nodes)-1])
+		if r.trailingSlashDir && trailingSlash && kind != LeafDirectory {
+			return fmt.Errorf("%w: %s", ErrNotADirectory, fpath)
+		}
+		last = c
+		return nil
+	})
 	if err != nil {
-		return nil, nil, err
+		return cid.Cid{}, LeafOther, err
 	}
-	if len(nodes) < 1 {
-		return nil, nil, fmt.Errorf("path %v did not resolve to a node", fpath)
-	}
-	return nodes[len(nodes)-1], cidlink.Link{Cid: c}, nil
+	return last, kind, nil
 }
 
-// ResolveSingle simply resolves one hop of a path through a graph with no
-// extra context (does not opaquely resolve through sharded nodes)
-// Deprecated: fetch node as ipld-prime or convert it and then use a selector to traverse through it.
-func ResolveSingle(ctx context.Context, ds format.NodeGetter, nd format.Node, names []string) (*format.Link, []string, error) {
-	return nd.ResolveLink(names)
+// leafKind returns the kind of nd, found depth nodes below the root of the
+// block c.
+func leafKind(c cid.Cid, depth int, nd ipld.Node) LeafKind {
+	// only block roots can be files, directories, symlinks or raw blocks
+	if depth > 0 {
+		return LeafOther
+	}
+	if c.Prefix().Codec == cid.Raw {
+		return LeafRaw
+	}
+	fsdata, ok := unixfsData(nd)
+	if !ok {
+		return LeafOther
+	}
+	switch fsdata.FieldDataType().Int() {
+	case data.Data_File, data.Data_Raw:
+		return LeafFile
+	case data.Data_Directory, data.Data_HAMTShard:
+		return LeafDirectory
+	case data.Data_Symlink:
+		return LeafSymlink
+	default:
+		return LeafOther
+	}
 }
 
-// ResolvePathComponents fetches the nodes for each segment of the given path.
-// It uses the first path component as a hash (key) of the first node, then
-// resolves all other components walking the links via a selector traversal
-//
-// Note: if/when the context is cancelled or expires then if a multi-block ADL node is returned then it may not be
-// possible to load certain values.
-func (r *Resolver) ResolvePathComponents(ctx context.Context, fpath path.Path) ([]ipld.Node, error) {
-	//lint:ignore SA1019 TODO: replace EventBegin
-	evt := log.EventBegin(ctx, "resolvePathComponents", logging.LoggableMap{"fpath": fpath})
-	defer evt.Done()
+// Entry is an entry of a UnixFS directory. Size is the cumulative size of the
+// DAG the entry links to (the Tsize of its link), or -1 if it is unknown.
+type Entry struct {
+	Name string
+	Cid  cid.Cid
+	Size int64
+}
 
-	// validate path
-	if err := fpath.IsValid(); err != nil {
-		evt.Append(logging.LoggableMap{"error": err.Error()})
+// ResolveEntries resolves the given path to a UnixFS directory, and returns its
+// immediate entries, in the order they are stored in. Sharded directories are
+// listed as a whole, fetching their shards as needed. If the path resolves to
+// anything but a directory, the error matches ErrNotADirectory.
+func (r *Resolver) ResolveEntries(ctx context.Context, fpath path.Path) ([]Entry, error) {
+	nd, lnk, err := r.ResolvePath(ctx, fpath)
+	if err != nil {
 		return nil, err
 	}
 
-	c, p, err := path.SplitAbsPath(fpath)
-	if err != nil {
-		evt.Append(logging.LoggableMap{"error": err.Error()})
-		return nil, err
+	fsdata, ok := unixfsData(nd)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotADirectory, fpath)
+	}
+	dataType := fsdata.FieldDataType().Int()
+	if dataType != data.Data_Directory && dataType != data.Data_HAMTShard {
+		return nil, fmt.Errorf("%w: %s", ErrNotADirectory, fpath)
 	}
 
-	// create a selector to traverse and match all path segments
-	pathSelector := pathAllSelector(p)
+	// without reification (e.g. for /ipld/ paths), the links of a basic
+	// directory are its entries, but those of a sharded directory are not
+	if _, ok := nd.(dagpb.PBNode); ok && dataType == data.Data_HAMTShard {
+		return nil, fmt.Errorf("cannot list sharded directory %s without reification", lnk)
+	}
 
-	nodes, _, _, err := r.resolveNodes(ctx, c, pathSelector)
-	if err != nil {
-		evt.Append(logging.LoggableMap{"error": err.Error()})
+	// the links of directories are read directly for their Tsize, which the
+	// map view of a directory does not hold
+	if pbnd, ok := nd.(interface{ FieldLinks() dagpb.PBLinks }); ok {
+		var entries []Entry
+		add := func(l dagpb.PBLink, name string) error {
+			if r.maxEntries > 0 && len(entries) == r.maxEntries {
+				return fmt.Errorf("%w: %s", ErrTooManyEntries, fpath)
+			}
+			cidLnk, ok := l.FieldHash().Link().(cidlink.Link)
+			if !ok {
+				return fmt.Errorf("link is not a cidlink: %v", l.FieldHash().Link())
+			}
+			size := int64(-1)
+			if l.FieldTsize().Exists() {
+				size = l.FieldTsize().Must().Int()
+			}
+			entries = append(entries, Entry{Name: name, Cid: cidLnk.Cid, Size: size})
+			return nil
+		}
+
+		if dataType == data.Data_Directory {
+			if r.maxEntries > 0 && pbnd.FieldLinks().Length() > int64(r.maxEntries) {
+				return nil, fmt.Errorf("%w: %s", ErrTooManyEntries, fpath)
+			}
+			itr := pbnd.FieldLinks().Iterator()
+			for !itr.Done() {
+				_, l := itr.Next()
+				var name string
+				if l.FieldName().Exists() {
+					name = l.FieldName().Must().String()
+				}
+				if err := add(l, name); err != nil {
+					return nil, err
+				}
+			}
+			return entries, nil
+		}
+
+		r = r.forPath(fpath)
+		if err := r.shardEntries(ctx, r.newBlockLoader(ctx), pbnd.FieldLinks(), fsdata, add); err != nil {
+			return nil, err
+		}
+		return entries, nil
 	}
 
-	return nodes, err
+	var entries []Entry
+	itr := nd.MapIterator()
+	for !itr.Done() {
+		if r.maxEntries > 0 && len(entries) == r.maxEntries {
+			return nil, fmt.Errorf("%w: %s", ErrTooManyEntries, fpath)
+		}
+		k, v, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		name, err := k.AsString()
+		if err != nil {
+			return nil, err
+		}
+		l, err := v.AsLink()
+		if err != nil {
+			return nil, err
+		}
+		cidLnk, ok := l.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("link is not a cidlink: %v", l)
+		}
+		entries = append(entries, Entry{Name: name, Cid: cidLnk.Cid, Size: -1})
+	}
+	return entries, nil
 }
 
-// ResolveLinks iteratively resolves names by walking the link hierarchy.
-// Every node is fetched from the Fetcher, resolving the next name.
-// Returns the list of nodes forming the path, starting with ndd. This list is
-// guaranteed never to be empty.
-//
-// ResolveLinks(nd, []string{"foo", "bar", "baz"})
+// shardEntries calls add with every entry of the HAMT shard made of links and
+// fsdata, and of its child shards, depth first as unixfsnode lists them. The
+// name of an entry is the name of its link without the hex prefix indexing it.
+func (r *Resolver) shardEntries(ctx context.Context, loader *blockLoader, links dagpb.PBLinks, fsdata data.UnixFSData, add func(dagpb.PBLink, string) error) error {
+	if !fsdata.FieldFanout().Exists() {
+		return fmt.Errorf("HAMT shard without fanout")
+	}
+	padLen := len(fmt.Sprintf("%X", fsdata.FieldFanout().Must().Int()-1))
+	itr := links.Iterator()
+	for !itr.Done() {
+		_, l := itr.Next()
+		if !l.FieldName().Exists() || len(l.FieldName().Must().String()) < padLen {
+			return fmt.Errorf("invalid HAMT shard link name")
+		}
+		name := l.FieldName().Must().String()
+		if len(name) > padLen {
+			if err := add(l, name[padLen:]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		cidLnk, ok := l.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			return fmt.Errorf("link is not a cidlink: %v", l.FieldHash().Link())
+		}
+		child, err := r.load(ctx, loader, cidLnk.Cid)
+		if err != nil {
+			return err
+		}
+		childData, ok := unixfsData(child)
+		pbchild, isPB := child.(interface{ FieldLinks() dagpb.PBLinks })
+		if !ok || !isPB || childData.FieldDataType().Int() != data.Data_HAMTShard {
+			return fmt.Errorf("%s is not a HAMT shard", cidLnk.Cid)
+		}
+		if err := r.shardEntries(ctx, loader, pbchild.FieldLinks(), childData, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Glob resolves base to a UnixFS directory, and returns the paths of its
+// entries whose name matches pattern, with the syntax of the Match function of
+// the standard path package. Only the immediate entries of the directory are
+// matched, so the pattern cannot contain a '/'.
+func (r *Resolver) Glob(ctx context.Context, base path.Path, pattern string) ([]path.Path, error) {
+	if strings.Contains(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q matches more than one level", pattern)
+	}
+	// report malformed patterns even for empty directories
+	if _, err := gopath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	entries, err := r.ResolveEntries(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(base.String(), "/") + "/"
+	var matches []path.Path
+	for _, e := range entries {
+		// skip the names no path segment can hold
+		if e.Name == "" || (!r.escapedSegments && strings.Contains(e.Name, "/")) {
+			continue
+		}
+		if ok, _ := gopath.Match(pattern, e.Name); ok {
+			matches = append(matches, path.FromString(prefix+r.escapeSegments([]string{e.Name})[0]))
+		}
+	}
+	return matches, nil
+}
+
+// ResolveFileBytes resolves the given path to a UnixFS file (or a raw block),
+// and returns its contents, fetching every block of the file. If the path
+// resolves to anything else, the error matches ErrNotAFile. Files larger than
+// max bytes are not read further than max, and fail with ErrFileTooLarge.
+func (r *Resolver) ResolveFileBytes(ctx context.Context, fpath path.Path, max int64) ([]byte, error) {
+	nd, lnk, err := r.ResolvePath(ctx, fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	r = r.forPath(fpath)
+	loader := r.newBlockLoader(ctx)
+	contents := []byte{}
+	var read func(c cid.Cid, nd ipld.Node) error
+	read = func(c cid.Cid, nd ipld.Node) error {
+		add := func(b []byte) error {
+			if int64(len(contents)+len(b)) > max {
+				return fmt.Errorf("%w: %s is larger than %d bytes", ErrFileTooLarge, fpath, max)
+			}
+			contents = append(contents, b...)
+			return nil
+		}
+
+		if c.Prefix().Codec == cid.Raw && nd.Kind() == ipld.Kind_Bytes {
+			b, err := nd.AsBytes()
+			if err != nil {
+				return err
+			}
+			return add(b)
+		}
+
+		fsdata, ok := unixfsData(nd)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNotAFile, fpath)
+		}
+		if t := fsdata.FieldDataType().Int(); t != data.Data_File && t != data.Data_Raw {
+			return fmt.Errorf("%w: %s", ErrNotAFile, fpath)
+		}
+		// fail early rather than after fetching max bytes
+		if fsdata.FieldFileSize().Exists() && int64(len(contents))+fsdata.FieldFileSize().Must().Int() > max {
+			return fmt.Errorf("%w: %s is larger than %d bytes", ErrFileTooLarge, fpath, max)
+		}
+		if fsdata.FieldData().Exists() {
+			if err := add(fsdata.FieldData().Must().Bytes()); err != nil {
+				return err
+			}
+		}
+
+		pbnd, ok := nd.(interface{ FieldLinks() dagpb.PBLinks })
+		if !ok {
+			return nil
+		}
+		itr := pbnd.FieldLinks().Iterator()
+		for !itr.Done() {
+			_, l := itr.Next()
+			cidLnk, ok := l.FieldHash().Link().(cidlink.Link)
+			if !ok {
+				return fmt.Errorf("link is not a cidlink: %v", l.FieldHash().Link())
+			}
+			child, err := r.load(ctx, loader, cidLnk.Cid)
+			if err != nil {
+				return err
+			}
+			if err := read(cidLnk.Cid, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := read(lnk.(cidlink.Link).Cid, nd); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// ResolveFileReader resolves the given path to a UnixFS file (or a raw block),
+// and returns a reader over its contents along with its size. Blocks are only
+// fetched as they are read, with ctx, so the reader must not outlive it. If the
+// path resolves to anything else, such as a directory or a symlink, the error
+// matches ErrNotAFile.
+func (r *Resolver) ResolveFileReader(ctx context.Context, fpath path.Path) (io.ReadSeeker, int64, error) {
+	nd, lnk, err := r.ResolvePath(ctx, fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r = r.forPath(fpath)
+	f := &fileReader{
+		ctx:    ctx,
+		r:      r,
+		loader: r.newBlockLoader(ctx),
+		c:      lnk.(cidlink.Link).Cid,
+		nd:     nd,
+	}
+	if _, err := fileContents(f.c, nd); err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", err, fpath)
+	}
+	f.size, err = f.nodeSize(f.c, nd)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, f.size, nil
+}
+
+// fileChunks holds the contents of a single block of a file: the bytes it holds
+// itself, followed by those of its children, whose sizes are given by
+// blockSizes when known.
+type fileChunks struct {
+	data       []byte
+	links      []cid.Cid
+	blockSizes []int64
+	fileSize   int64 // -1 if unknown
+}
+
+// fileContents returns the fileChunks of nd, or an error matching ErrNotAFile
+// if nd is not part of a UnixFS file or a raw block.
+func fileContents(c cid.Cid, nd ipld.Node) (fileChunks, error) {
+	if c.Prefix().Codec == cid.Raw && nd.Kind() == ipld.Kind_Bytes {
+		b, err := nd.AsBytes()
+		if err != nil {
+			return fileChunks{}, err
+		}
+		return fileChunks{data: b, fileSize: int64(len(b))}, nil
+	}
+
+	fsdata, ok := unixfsData(nd)
+	if !ok {
+		return fileChunks{}, ErrNotAFile
+	}
+	if t := fsdata.FieldDataType().Int(); t != data.Data_File && t != data.Data_Raw {
+		return fileChunks{}, ErrNotAFile
+	}
+	chunks := fileChunks{fileSize: -1}
+	if fsdata.FieldData().Exists() {
+		chunks.data = fsdata.FieldData().Must().Bytes()
+	}
+	if fsdata.FieldFileSize().Exists() {
+		chunks.fileSize = fsdata.FieldFileSize().Must().Int()
+	}
+	if pbnd, ok := nd.(interface{ FieldLinks() dagpb.PBLinks }); ok {
+		itr := pbnd.FieldLinks().Iterator()
+		for !itr.Done() {
+			_, l := itr.Next()
+			cidLnk, ok := l.FieldHash().Link().(cidlink.Link)
+			if !ok {
+				return fileChunks{}, fmt.Errorf("link is not a cidlink: %v", l.FieldHash().Link())
+			}
+			chunks.links = append(chunks.links, cidLnk.Cid)
+		}
+	}
+	sizes := fsdata.FieldBlockSizes()
+	// sizes not matching the links cannot be relied upon
+	if sizes.Length() == int64(len(chunks.links)) {
+		itr := sizes.Iterator()
+		for !itr.Done() {
+			_, s := itr.Next()
+			chunks.blockSizes = append(chunks.blockSizes, s.Int())
+		}
+	}
+	return chunks, nil
+}
+
+// fileReader reads a file from its root block, fetching the blocks holding the
+// bytes at the current offset on every read.
+type fileReader struct {
+	ctx    context.Context
+	r      *Resolver
+	loader *blockLoader
+	c      cid.Cid
+	nd     ipld.Node
+	size   int64
+	offset int64
+}
+
+// nodeSize returns the size of the contents below nd, fetching its children
+// if it does not record it.
+func (f *fileReader) nodeSize(c cid.Cid, nd ipld.Node) (int64, error) {
+	chunks, err := fileContents(c, nd)
+	if err != nil {
+		return 0, err
+	}
+	if chunks.fileSize >= 0 {
+		return chunks.fileSize, nil
+	}
+	size := int64(len(chunks.data))
+	for i := range chunks.links {
+		s, err := f.childSize(chunks, i)
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, nil
+}
+
+func (f *fileReader) childSize(chunks fileChunks, i int) (int64, error) {
+	if chunks.blockSizes != nil {
+		return chunks.blockSizes[i], nil
+	}
+	child, err := f.r.load(f.ctx, f.loader, chunks.links[i])
+	if err != nil {
+		return 0, err
+	}
+	return f.nodeSize(chunks.links[i], child)
+}
+
+// readAt copies the contents below nd starting at off into b, up to the end of
+// the block holding the byte at off.
+func (f *fileReader) readAt(c cid.Cid, nd ipld.Node, off int64, b []byte) (int, error) {
+	chunks, err := fileContents(c, nd)
+	if err != nil {
+		return 0, err
+	}
+	if off < int64(len(chunks.data)) {
+		return copy(b, chunks.data[off:]), nil
+	}
+	off -= int64(len(chunks.data))
+	for i, l := range chunks.links {
+		s, err := f.childSize(chunks, i)
+		if err != nil {
+			return 0, err
+		}
+		if off < s {
+			child, err := f.r.load(f.ctx, f.loader, l)
+			if err != nil {
+				return 0, err
+			}
+			return f.readAt(l, child, off, b)
+		}
+		off -= s
+	}
+	return 0, io.EOF
+}
+
+func (f *fileReader) Read(b []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if rest := f.size - f.offset; int64(len(b)) > rest {
+		b = b[:rest]
+	}
+	n := 0
+	for n < len(b) {
+		read, err := f.readAt(f.c, f.nd, f.offset, b[n:])
+		n += read
+		f.offset += int64(read)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return f.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return f.offset, fmt.Errorf("negative offset: %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// ResolvePath fetches the node for given path. It returns the last item
+// returned by ResolvePathComponents and the last link traversed which can be used to recover the block.
+// When a path segment cannot be found, the error is an ErrNoLink naming the
+// segment and the block it was looked up in.
+//
+// Note: if/when the context is cancelled or expires then if a multi-block ADL node is returned then it may not be
+// possible to load certain values.
+func (r *Resolver) ResolvePath(ctx context.Context, fpath path.Path) (ipld.Node, ipld.Link, error) {
+	r = r.forPath(fpath)
+	trailingSlash := fpath.HasTrailingSlash()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return nil, nil, err
+	}
+
+	var nd ipld.Node
+	var last cid.Cid
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		nodes, c, depth, err := r.resolveNodes(ctx, c, p, nil)
+		if err != nil {
+			return err
+		}
+		if len(nodes) < 1 {
+			return fmt.Errorf("path %v did not resolve to a node", fpath)
+		} else if len(nodes) < len(p)+1 {
+			return ErrNoLink{Name: p[len(nodes)-1], Node: c}
+		}
+
+		nd, last = nodes[len(nodes)-1], c
+		if r.trailingSlashDir && trailingSlash && leafKind(c, depth, nd) != LeafDirectory {
+			return fmt.Errorf("%w: %s", ErrNotADirectory, fpath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.rawLeavesAsFiles && last.Prefix().Codec == cid.Raw && nd.Kind() == ipld.Kind_Bytes {
+		nd, err = rawLeafAsFile(nd)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return nd, cidlink.Link{Cid: last}, nil
+}
+
+// ResolveN resolves only the first n segments (following the root) of the
+// given path, and returns the node reached, the cid of the block holding it
+// and the segments left unresolved. A path with at most n segments is fully
+// resolved, as with ResolvePath, leaving no segments; with n = 0, the root of
+// the path is returned.
+func (r *Resolver) ResolveN(ctx context.Context, fpath path.Path, n int) (cid.Cid, ipld.Node, []string, error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, nil, nil, err
+	}
+	if n < 0 {
+		return cid.Cid{}, nil, nil, fmt.Errorf("cannot resolve %d segments", n)
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return cid.Cid{}, nil, nil, err
+	}
+
+	var last cid.Cid
+	var nd ipld.Node
+	var rest []string
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+		m := n
+		if m > len(p) {
+			m = len(p)
+		}
+
+		nodes, c, _, err := r.resolveNodes(ctx, c, p[:m], nil)
+		if err != nil {
+			return err
+		}
+		if len(nodes) < 1 {
+			return fmt.Errorf("path %v did not resolve to a node", fpath)
+		} else if len(nodes) < m+1 {
+			return ErrNoLink{Name: p[len(nodes)-1], Node: c}
+		}
+		last, nd, rest = c, nodes[len(nodes)-1], append([]string{}, p[m:]...)
+		return nil
+	})
+	if err != nil {
+		return cid.Cid{}, nil, nil, err
+	}
+	return last, nd, rest, nil
+}
+
+// ResolveExistingPrefix resolves as much of the given path as exists, and
+// returns the cid of the block holding the deepest node reached along with the
+// segments that could not be found from there, such as those of the files and
+// directories left to create. Unlike with ResolvePath, a missing tail is not an
+// error, and no segments are left for a path that exists as a whole. Blocks
+// that cannot be fetched, including the shards of sharded directories, still
+// fail the resolution.
+func (r *Resolver) ResolveExistingPrefix(ctx context.Context, fpath path.Path) (cid.Cid, []string, error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	var last cid.Cid
+	var rest []string
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		found := 0
+		err = r.walk(ctx, c, p, nil, func(_ fetcher.FetchResult, blk cid.Cid, _ bool) error {
+			last = blk
+			found++
+			return nil
+		})
+		// an index past the end of a list is missing like any other segment
+		if err != nil && !errors.Is(err, ErrIndexOutOfRange) {
+			return err
+		}
+		if found == 0 {
+			return fmt.Errorf("path %v did not resolve to a node", fpath)
+		}
+		rest = append([]string{}, p[found-1:]...)
+		return nil
+	})
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	return last, rest, nil
+}
+
+// ResolvePathPartial is like ResolvePath, but also reports how far the
+// resolution got when it fails: the cid of the block holding the last node
+// resolved, the number of path segments (after the root) consumed to reach
+// it, and that node. When a segment cannot be found, consumed is its index
+// and the error is an ErrNoLink. On success, consumed is the number of
+// segments and node is the node the path resolves to. lastCid is undefined
+// and node is nil if not even the root could be resolved. When following
+// symlinks (see WithFollowSymlinks), consumed counts the segments of the path
+// rewritten to cross them.
+func (r *Resolver) ResolvePathPartial(ctx context.Context, fpath path.Path) (lastCid cid.Cid, consumed int, node ipld.Node, err error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return cid.Undef, 0, nil, err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return cid.Undef, 0, nil, err
+	}
+
+	err = r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		lastCid, consumed, node = cid.Undef, 0, nil
+		matched := 0
+		err = r.walk(ctx, c, p, nil, func(res fetcher.FetchResult, blk cid.Cid, newBlock bool) error {
+			matched++
+			lastCid = blk
+			node = res.Node
+			return nil
+		})
+		if matched > 0 {
+			consumed = matched - 1
+		}
+		if err == nil && consumed < len(p) {
+			err = ErrNoLink{Name: p[consumed], Node: lastCid}
+		}
+		return err
+	})
+	return lastCid, consumed, node, err
+}
+
+// ResolvePathWalk walks the given path, calling visit every time a link is
+// crossed with the cid of the block landed on and the path segment naming the
+// link. Unlike ResolvePathComponents, the traversed nodes are not kept in
+// memory. If visit returns an error, the walk stops and returns that error.
+// When a segment cannot be found, the error is an ErrNoLink. When following
+// symlinks (see WithFollowSymlinks), visit is only called once the walk is
+// over, for the links of the path rewritten to cross them.
+func (r *Resolver) ResolvePathWalk(ctx context.Context, fpath path.Path, visit func(cid.Cid, string) error) error {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return err
+	}
+
+	type crossing struct {
+		blk  cid.Cid
+		name string
+	}
+	var crossed []crossing
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		// a walk that stops at a symlink is walked again, so its links are
+		// only visited once it reaches none
+		crossed = nil
+		matched := 0
+		var last cid.Cid
+		err = r.walk(ctx, c, p, nil, func(res fetcher.FetchResult, blk cid.Cid, newBlock bool) error {
+			matched++
+			last = blk
+			if !newBlock || res.Path.Len() == 0 {
+				return nil
+			}
+			if r.stopAtSymlinks {
+				crossed = append(crossed, crossing{blk, res.Path.Last().String()})
+				return nil
+			}
+			return visit(blk, res.Path.Last().String())
+		})
+		var symlink *errSymlink
+		if errors.As(err, &symlink) {
+			crossed = nil
+		}
+		if err != nil {
+			return err
+		}
+		if matched < 1 {
+			return fmt.Errorf("path %v did not resolve to a node", fpath)
+		} else if matched < len(p)+1 {
+			return ErrNoLink{Name: p[matched-1], Node: last}
+		}
+		return nil
+	})
+	for _, c := range crossed {
+		if err := visit(c.blk, c.name); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// ResolveRoot fetches the node at the root of the given path, ignoring the
+// rest of the path. An error is returned for /ipns/ paths, whose name must be
+// resolved to an /ipfs/ path first.
+func (r *Resolver) ResolveRoot(ctx context.Context, fpath path.Path) (cid.Cid, ipld.Node, error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	if ns, _ := fpath.Namespace(); ns == "ipns" {
+		return cid.Cid{}, nil, fmt.Errorf("cannot resolve the root of %s: the /ipns/ name must be resolved first", fpath)
+	}
+
+	c, err := fpath.RootCid()
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	nd, _, err := r.fetch(ctx, r.newBlockLoader(ctx), c, nil, false)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	return c, nd, nil
+}
+
+// ResolveSingle looks up name in nd. If it names a link, the linked block is
+// fetched and returned along with the link; otherwise, the node found within
+// the block of nd is returned with a nil link. When name cannot be found, the
+// error is an ErrNoLink with an undefined Node; other errors looking it up,
+// such as failures to load the shards of a sharded directory, are returned as
+// they are.
+func (r *Resolver) ResolveSingle(ctx context.Context, nd ipld.Node, name string) (ipld.Node, ipld.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	child, err := r.lookup(nd, name)
+	if isNoSuchLink(err) {
+		return nil, nil, ErrNoLink{Name: name}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if child.Kind() != ipld.Kind_Link {
+		return child, nil, nil
+	}
+
+	lnk, err := child.AsLink()
+	if err != nil {
+		return nil, nil, err
+	}
+	clnk, ok := lnk.(cidlink.Link)
+	if !ok {
+		return nil, nil, fmt.Errorf("link is not a cidlink: %v", lnk)
+	}
+
+	child, _, err = r.fetch(ctx, r.newBlockLoader(ctx), clnk.Cid, []string{name}, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return child, clnk, nil
+}
+
+// ResolveSingle simply resolves one hop of a path through a graph with no
+// extra context (does not opaquely resolve through sharded nodes)
+// Deprecated: fetch node as ipld-prime or convert it and then use a selector to traverse through it.
+func ResolveSingle(ctx context.Context, ds format.NodeGetter, nd format.Node, names []string) (*format.Link, []string, error) {
+	return nd.ResolveLink(names)
+}
+
+// ResolvePathComponents fetches the nodes for each segment of the given path.
+// It uses the first path component as a hash (key) of the first node, then
+// resolves all other components walking the links one block at a time.
+//
+// Note: if/when the context is cancelled or expires then if a multi-block ADL node is returned then it may not be
+// possible to load certain values.
+func (r *Resolver) ResolvePathComponents(ctx context.Context, fpath path.Path) ([]ipld.Node, error) {
+	r = r.forPath(fpath)
+	//lint:ignore SA1019 TODO: replace EventBegin
+	evt := log.EventBegin(ctx, "resolvePathComponents", logging.LoggableMap{"fpath": fpath})
+	defer evt.Done()
+
+	if err := ctx.Err(); err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return nil, err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return nil, err
+	}
+
+	var nodes []ipld.Node
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, err := r.splitPath(fpath)
+		if err != nil {
+			return err
+		}
+
+		nodes, _, _, err = r.resolveNodes(ctx, c, p, nil)
+		return err
+	})
+	if err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// ResolveStep is a node reached while resolving a path.
+type ResolveStep struct {
+	// Name is the path segment the node was reached through; it is empty for
+	// the root of the path.
+	Name string
+	// RawName is Name as written in the path, before being unescaped or
+	// percent-decoded as configured with WithEscapedSegments and
+	// WithPercentDecodedSegments.
+	RawName string
+	// Cid is the cid of the block containing the node.
+	Cid cid.Cid
+	// Node is the node reached.
+	Node ipld.Node
+	// BlockSize is the size of the block fetched to reach the node, measured
+	// as in ResolveStats (including any Metadata node stepped through). It is
+	// 0 for nodes within the block of the previous step.
+	BlockSize int
+}
+
+// ResolvePathSteps is like ResolvePathComponents, but returns every node along
+// with the path segment naming it and the cid of its block. The first step is
+// the root of the path.
+func (r *Resolver) ResolvePathSteps(ctx context.Context, fpath path.Path) ([]ResolveStep, error) {
+	r = r.forPath(fpath)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// validate path
+	if err := fpath.IsValid(); err != nil {
+		return nil, err
+	}
+
+	var steps []ResolveStep
+	err := r.followingSymlinks(fpath, func(r *Resolver, fpath path.Path) error {
+		c, p, raw, err := r.splitPathRaw(fpath)
+		if err != nil {
+			return err
+		}
+
+		steps = nil
+		var stats ResolveStats
+		fetched := 0
+		return r.walk(ctx, c, p, &stats, func(res fetcher.FetchResult, blk cid.Cid, newBlock bool) error {
+			step := ResolveStep{Cid: blk, Node: res.Node}
+			if newBlock {
+				step.BlockSize = stats.Bytes - fetched
+				fetched = stats.Bytes
+			}
+			if len(steps) > 0 {
+				step.Name = p[len(steps)-1]
+				step.RawName = raw[len(steps)-1]
+			}
+			steps = append(steps, step)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// ResolveLinks iteratively resolves names by walking the link hierarchy.
+// Every node is fetched from the Fetcher, resolving the next name.
+// Returns the list of nodes forming the path, in traversal order: ndd comes
+// first and the node named by the last name comes last, so a fully resolved
+// list holds len(names)+1 nodes. This list is guaranteed never to be empty.
+//
+// ResolveLinks(nd, []string{"foo", "bar", "baz"})
 // would retrieve "baz" in ("bar" in ("foo" in nd.Links).Links).Links
 //
 // Note: if/when the context is cancelled or expires then if a multi-block ADL node is returned then it may not be
@@ -205,47 +1773,248 @@ func (r *Resolver) ResolveLinks(ctx context.Context, ndd ipld.Node, names []stri
 	evt := log.EventBegin(ctx, "resolveLinks", logging.LoggableMap{"names": names})
 	defer evt.Done()
 
-	// create a selector to traverse and match all path segments
-	pathSelector := pathAllSelector(names)
+	// create a selector to traverse and match all path segments
+	pathSelector := pathAllSelector(names)
+
+	session := r.withReifier(r.FetcherFactory).NewSession(ctx)
+
+	// traverse selector; ndd itself is the first match
+	nodes := []ipld.Node{}
+	err := session.NodeMatching(ctx, ndd, pathSelector, func(res fetcher.FetchResult) error {
+		nodes = append(nodes, res.Node)
+		return nil
+	})
+	if err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return nil, err
+	}
+
+	return nodes, err
+}
+
+// errSymlink is returned by the walks of a resolver that stops at symlinks
+// when the node reached through the first at segments is a UnixFS symlink.
+type errSymlink struct {
+	at     int
+	target string
+}
 
-	session := r.FetcherFactory.NewSession(ctx)
+func (e *errSymlink) Error() string {
+	return fmt.Sprintf("symlink to %q at segment %d", e.target, e.at)
+}
 
-	// traverse selector
-	nodes := []ipld.Node{ndd}
-	err := session.NodeMatching(ctx, ndd, pathSelector, func(res fetcher.FetchResult) error {
-		nodes = append(nodes, res.Node)
-		return nil
+// followingSymlinks calls resolve with r and fpath. When the resolver is
+// configured to follow symlinks, resolve is called with a copy of r whose walks
+// stop at the first symlink they reach, and called again with fpath rewritten
+// to cross that symlink, until it reaches none.
+func (r *Resolver) followingSymlinks(fpath path.Path, resolve func(r *Resolver, fpath path.Path) error) error {
+	if !r.followSymlinks {
+		return resolve(r, fpath)
+	}
+
+	sr := *r
+	sr.stopAtSymlinks = true
+	for hops := 0; ; hops++ {
+		err := resolve(&sr, fpath)
+		var symlink *errSymlink
+		if !errors.As(err, &symlink) {
+			return err
+		}
+		if hops == maxSymlinks {
+			return ErrTooManySymlinks
+		}
+		fpath, err = r.crossSymlink(fpath, symlink.at, symlink.target)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// crossSymlink returns fpath rewritten to go through target, the target of the
+// symlink reached through its first i segments.
+func (r *Resolver) crossSymlink(fpath path.Path, i int, target string) (path.Path, error) {
+	c, p, err := r.splitPath(fpath)
+	if err != nil {
+		return "", err
+	}
+
+	rest := p[i:]
+	if strings.HasPrefix(target, "/") {
+		tpath, err := path.ParsePath(target)
+		if err != nil {
+			return "", err
+		}
+		if ns, _ := tpath.Namespace(); ns != "ipfs" {
+			return "", fmt.Errorf("symlink %q does not point to an /ipfs/ path", path.Join(p[:i]))
+		}
+		c, p, err = path.SplitAbsPath(tpath)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		p = append(append(p[:i-1:i-1], strings.Split(target, "/")...), p[i:]...)
+		rest = nil
+	}
+
+	// resolve "." and ".." in the rewritten path
+	return path.FromString("/ipfs/" + c.String() + "/" + path.Join(r.escapeSegments(append(p, rest...)))).Normalize()
+}
+
+// rawLeafAsFile returns a dag-pb node holding a UnixFS file with the bytes of
+// the raw leaf nd.
+func rawLeafAsFile(nd ipld.Node) (ipld.Node, error) {
+	b, err := nd.AsBytes()
+	if err != nil {
+		return nil, err
+	}
+	fsdata, err := databuilder.BuildUnixFS(func(fb *databuilder.Builder) {
+		databuilder.DataType(fb, data.Data_File)
+		databuilder.Data(fb, b)
+		databuilder.FileSize(fb, uint64(len(b)))
 	})
 	if err != nil {
-		evt.Append(logging.LoggableMap{"error": err.Error()})
 		return nil, err
 	}
+	return qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(0, func(ipld.ListAssembler) {}))
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(fsdata)))
+	})
+}
+
+// splitPath splits fpath like path.SplitAbsPath, decoding the segments as
+// configured with WithEscapedSegments and WithPercentDecodedSegments.
+func (r *Resolver) splitPath(fpath path.Path) (cid.Cid, []string, error) {
+	c, p, _, err := r.splitPathRaw(fpath)
+	return c, p, err
+}
+
+// splitPathRaw is like splitPath, but also returns every segment as written
+// in fpath.
+func (r *Resolver) splitPathRaw(fpath path.Path) (cid.Cid, []string, []string, error) {
+	c, p, err := path.SplitAbsPath(fpath)
+	if err != nil || (!r.escapedSegments && !r.percentDecoded) {
+		return c, p, p, err
+	}
 
-	return nodes, err
+	raw := p
+	if r.escapedSegments {
+		raw = nil
+		var cur strings.Builder
+		for i, seg := range p {
+			cur.WriteString(seg)
+			// an odd number of trailing backslashes escapes the delimiter
+			n := len(seg) - len(strings.TrimRight(seg, "\\"))
+			if n%2 == 1 && i < len(p)-1 {
+				cur.WriteByte('/')
+				continue
+			}
+			raw = append(raw, cur.String())
+			cur.Reset()
+		}
+	}
+
+	segments := make([]string, len(raw))
+	for i, seg := range raw {
+		if r.escapedSegments {
+			seg = unescapeSegment(seg)
+		}
+		if r.percentDecoded {
+			decoded, err := url.PathUnescape(seg)
+			if err != nil {
+				return cid.Cid{}, nil, nil, fmt.Errorf("path segment %q: %w", raw[i], err)
+			}
+			seg = decoded
+		}
+		segments[i] = seg
+	}
+	return c, segments, raw, nil
 }
 
-// Finds nodes matching the selector starting with a cid. Returns the matched nodes, the cid of the block containing
-// the last node, and the depth of the last node within its block (root is depth 0).
-func (r *Resolver) resolveNodes(ctx context.Context, c cid.Cid, sel ipld.Node) ([]ipld.Node, cid.Cid, int, error) {
-	session := r.FetcherFactory.NewSession(ctx)
+func unescapeSegment(seg string) string {
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		if seg[i] == '\\' && i < len(seg)-1 {
+			i++
+		}
+		b.WriteByte(seg[i])
+	}
+	return b.String()
+}
 
-	// traverse selector
-	lastLink := cid.Undef
-	depth := 0
-	nodes := []ipld.Node{}
-	err := fetcherhelpers.BlockMatching(ctx, session, cidlink.Link{Cid: c}, sel, func(res fetcher.FetchResult) error {
-		if res.LastBlockLink == nil {
-			res.LastBlockLink = cidlink.Link{Cid: c}
+// escapeSegments is the reverse of splitPath, returning the segments as they
+// are written in a path.
+func (r *Resolver) escapeSegments(segments []string) []string {
+	if !r.escapedSegments && !r.percentDecoded {
+		return segments
+	}
+	escaped := make([]string, len(segments))
+	for i, seg := range segments {
+		if r.percentDecoded {
+			seg = url.PathEscape(seg)
 		}
-		cidLnk, ok := res.LastBlockLink.(cidlink.Link)
-		if !ok {
-			return fmt.Errorf("link is not a cidlink: %v", cidLnk)
+		if r.escapedSegments {
+			seg = strings.NewReplacer("\\", "\\\\", "/", "\\/").Replace(seg)
 		}
+		escaped[i] = seg
+	}
+	return escaped
+}
+
+// symlinkTarget returns the target of nd if it is a UnixFS symlink, or an
+// empty string otherwise.
+func symlinkTarget(nd ipld.Node) string {
+	fsdata, ok := unixfsData(nd)
+	if !ok || fsdata.FieldDataType().Int() != data.Data_Symlink || !fsdata.FieldData().Exists() {
+		return ""
+	}
+	return string(fsdata.FieldData().Must().Bytes())
+}
+
+// metadataTarget returns the cid of the node wrapped by nd, if nd is a UnixFS
+// Metadata node and the resolver steps through those.
+func (r *Resolver) metadataTarget(nd ipld.Node) (cid.Cid, bool) {
+	if r.rawNodes {
+		return cid.Undef, false
+	}
+	fsdata, ok := unixfsData(nd)
+	if !ok || fsdata.FieldDataType().Int() != data.Data_Metadata {
+		return cid.Undef, false
+	}
+	pbnd, ok := nd.(interface{ FieldLinks() dagpb.PBLinks })
+	if !ok || pbnd.FieldLinks().Length() == 0 {
+		return cid.Undef, false
+	}
+	cidLnk, ok := pbnd.FieldLinks().Lookup(0).FieldHash().Link().(cidlink.Link)
+	if !ok {
+		return cid.Undef, false
+	}
+	return cidLnk.Cid, true
+}
 
-		// if we hit a block boundary
-		if !lastLink.Equals(cidLnk.Cid) {
+// unixfsData returns the UnixFS data of nd, if it is a UnixFS node.
+func unixfsData(nd ipld.Node) (data.UnixFSData, bool) {
+	pbnd, ok := nd.(interface{ FieldData() dagpb.MaybeBytes })
+	if !ok || !pbnd.FieldData().Exists() {
+		return nil, false
+	}
+	fsdata, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, false
+	}
+	return fsdata, true
+}
+
+// Finds the nodes reached by each of the segments starting with a cid. Returns the nodes, the cid of the block
+// containing the last node, and the depth of the last node within its block (root is depth 0).
+// If stats is not nil, it is updated with every block traversed.
+func (r *Resolver) resolveNodes(ctx context.Context, c cid.Cid, segments []string, stats *ResolveStats) ([]ipld.Node, cid.Cid, int, error) {
+	lastLink := cid.Undef
+	depth := 0
+	nodes := []ipld.Node{}
+	err := r.walk(ctx, c, segments, stats, func(res fetcher.FetchResult, blk cid.Cid, newBlock bool) error {
+		if newBlock {
 			depth = 0
-			lastLink = cidLnk.Cid
+			lastLink = blk
 		} else {
 			depth++
 		}
@@ -260,11 +2029,747 @@ func (r *Resolver) resolveNodes(ctx context.Context, c cid.Cid, sel ipld.Node) (
 	return nodes, lastLink, depth, nil
 }
 
-func pathLeafSelector(path []string) ipld.Node {
-	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
-	return pathSelector(path, ssb, func(p string, s builder.SelectorSpec) builder.SelectorSpec {
-		return ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) { efsb.Insert(p, s) })
-	})
+// walk resolves the segments one at a time starting with a cid, calling visit
+// for the root node and for each node reached through a segment, with the cid
+// of the block containing the node, and whether the node is the root of that
+// block (meaning a link was crossed to reach it, unless it is the root of the
+// traversal). Like a selector traversal, the walk ends without error at the
+// first segment that cannot be found, unless it is an out of range list index.
+// Blocks are fetched one hop at a time, so that every fetch can be controlled
+// and its errors are kept intact.
+// If stats is not nil, it is updated with every block traversed.
+func (r *Resolver) walk(ctx context.Context, c cid.Cid, segments []string, stats *ResolveStats, visit func(fetcher.FetchResult, cid.Cid, bool) error) error {
+	return r.walkBlocks(ctx, c, segments, stats, nil, visit)
+}
+
+// walkBlocks is like walk, but also calls onBlock, if not nil, with the cid of
+// every block fetched, in order, including the Metadata nodes stepped through.
+func (r *Resolver) walkBlocks(ctx context.Context, c cid.Cid, segments []string, stats *ResolveStats, onBlock func(cid.Cid), visit func(fetcher.FetchResult, cid.Cid, bool) error) error {
+	if stats != nil {
+		ctx = context.WithValue(ctx, statsKey{}, stats)
+	}
+	var limit *byteLimit
+	if r.maxBytes > 0 {
+		limit = &byteLimit{max: r.maxBytes}
+		ctx = context.WithValue(ctx, byteLimitKey{}, limit)
+	}
+	ctx, release := withHopContexts(ctx)
+	defer release()
+	loader := r.batchLoader
+	if loader == nil {
+		loader = r.newBlockLoader(ctx)
+	}
+
+	hops := 0
+	blk := c
+	visited := map[cid.Cid]struct{}{c: {}}
+	var nd ipld.Node
+	var p, blkPath ipld.Path
+	for i := 0; i <= len(segments); i++ {
+		// stop promptly rather than fetching the next block
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		newBlock := i == 0
+		if i > 0 {
+			next, err := r.lookupWithStats(nd, segments[i-1], stats)
+			if isNoSuchLink(err) {
+				return nil
+			} else if err != nil {
+				return lookupError(ctx, blk, err)
+			}
+			nd = next
+			p = p.AppendSegment(ipld.ParsePathSegment(segments[i-1]))
+
+			if nd.Kind() == ipld.Kind_Link {
+				lnk, err := nd.AsLink()
+				if err != nil {
+					return err
+				}
+				cidLnk, ok := lnk.(cidlink.Link)
+				if !ok {
+					return fmt.Errorf("link is not a cidlink: %v", lnk)
+				}
+				hops++
+				if r.maxDepth > 0 && hops > r.maxDepth {
+					return ErrPathTooDeep
+				}
+				blk = cidLnk.Cid
+				if _, ok := visited[blk]; ok {
+					return ErrCyclicLink
+				}
+				visited[blk] = struct{}{}
+				newBlock = true
+			}
+		}
+
+		for newBlock {
+			var err error
+			var size int
+			nd, size, err = r.fetch(ctx, loader, blk, segments[:i], stats != nil || r.maxBytes > 0)
+			if err != nil {
+				return err
+			}
+			if onBlock != nil {
+				onBlock(blk)
+			}
+			blkPath = p
+			if r.onCodec != nil {
+				r.onCodec(blk, blk.Prefix().Codec)
+			}
+
+			if limit != nil {
+				if err := limit.add(size); err != nil {
+					return err
+				}
+			}
+			if stats != nil {
+				stats.BlocksFetched++
+				stats.Bytes += size
+			}
+
+			// step through Metadata nodes to the node they wrap
+			wrapped, ok := r.metadataTarget(nd)
+			if !ok {
+				break
+			}
+			hops++
+			if r.maxDepth > 0 && hops > r.maxDepth {
+				return ErrPathTooDeep
+			}
+			if _, ok := visited[wrapped]; ok {
+				return ErrCyclicLink
+			}
+			visited[wrapped] = struct{}{}
+			blk = wrapped
+		}
+
+		// the root cannot be a symlink relative to a directory
+		if r.stopAtSymlinks && newBlock && i > 0 {
+			if target := symlinkTarget(nd); target != "" {
+				return &errSymlink{at: i, target: target}
+			}
+		}
+
+		res := fetcher.FetchResult{
+			Node:          nd,
+			Path:          p,
+			LastBlockPath: blkPath,
+			LastBlockLink: cidlink.Link{Cid: blk},
+		}
+		if err := visit(res, blk, newBlock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the node named by the path segment name within nd, falling
+// back to a case-insensitive match if the resolver is configured to.
+func (r *Resolver) lookup(nd ipld.Node, name string) (ipld.Node, error) {
+	if r.linkName != nil {
+		name = r.linkName(name)
+	}
+	seg := ipld.ParsePathSegment(name)
+	// check list bounds first, as some list implementations panic on
+	// negative indexes
+	if nd.Kind() == ipld.Kind_List {
+		if idx, err := seg.Index(); err == nil && (idx < 0 || idx >= nd.Length()) {
+			return nil, fmt.Errorf("%w: index %d of list of length %d", ErrIndexOutOfRange, idx, nd.Length())
+		}
+	}
+	next, err := nd.LookupBySegment(seg)
+	if err == nil || !r.caseInsensitive || nd.Kind() != ipld.Kind_Map {
+		return next, err
+	}
+
+	match := ""
+	for it := nd.MapIterator(); !it.Done(); {
+		k, _, ierr := it.Next()
+		if ierr != nil {
+			return nil, ierr
+		}
+		key, kerr := k.AsString()
+		if kerr != nil {
+			continue
+		}
+		if strings.EqualFold(key, name) && (match == "" || key < match) {
+			match = key
+		}
+	}
+	if match == "" {
+		return nil, err
+	}
+	return nd.LookupByString(match)
+}
+
+// lookupWithStats is like lookup, but if stats is not nil, a lookup within a
+// sharded directory counts its root shard in stats.ShardHops; the shards below
+// it are counted as they are loaded (see blockLoader).
+func (r *Resolver) lookupWithStats(nd ipld.Node, name string, stats *ResolveStats) (ipld.Node, error) {
+	if _, ok := nd.(hamt.UnixFSHAMTShard); ok && stats != nil {
+		stats.ShardHops++
+	}
+	return r.lookup(nd, name)
+}
+
+// fetch loads the block c reached through segments, within a span if the
+// resolver has a tracer, and records it in the resolver's transcript, if any.
+// The size of the block is returned as by loadSized.
+func (r *Resolver) fetch(ctx context.Context, loader *blockLoader, c cid.Cid, segments []string, sized bool) (ipld.Node, int, error) {
+	segment := ""
+	if len(segments) > 0 {
+		segment = segments[len(segments)-1]
+	}
+	if r.transcript != nil {
+		nd, size, err := r.traceFetch(ctx, loader, c, segment, sized)
+		r.transcript.mu.Lock()
+		*r.transcript.entries = append(*r.transcript.entries, TranscriptEntry{Segment: segment, Cid: c, Err: err})
+		r.transcript.mu.Unlock()
+		return nd, size, err
+	}
+	return r.traceFetch(ctx, loader, c, segment, sized)
+}
+
+func (r *Resolver) traceFetch(ctx context.Context, loader *blockLoader, c cid.Cid, segment string, sized bool) (ipld.Node, int, error) {
+	if r.tracer == nil {
+		return r.loadSized(ctx, loader, c, sized)
+	}
+
+	ctx, span := r.tracer.StartSpan(ctx, "resolver.fetch")
+	defer span.End()
+	span.SetAttribute("segment", segment)
+	span.SetAttribute("cid", c)
+
+	nd, size, err := r.loadSized(ctx, loader, c, sized)
+	if err != nil {
+		span.SetAttribute("error", err)
+	}
+	return nd, size, err
+}
+
+// load loads the block c as loadSized does, without measuring it unless ctx
+// has a budget.
+func (r *Resolver) load(ctx context.Context, loader *blockLoader, c cid.Cid) (ipld.Node, error) {
+	nd, _, err := r.loadSized(ctx, loader, c, false)
+	return nd, err
+}
+
+// loadSized loads the block c, within the per-hop timeout if the resolver has
+// one, and draws its size from the budget of ctx, if any. If sized is true,
+// the size of the raw data of the block is returned along with its root node.
+// Errors other than the block not being found or ctx being done are wrapped
+// in an ErrFetchFailed, and retried if the resolver is configured to.
+func (r *Resolver) loadSized(ctx context.Context, loader *blockLoader, c cid.Cid, sized bool) (ipld.Node, int, error) {
+	budgeted := hasBudget(ctx)
+	sized = sized || budgeted
+	nd, size, err := r.loadOnce(ctx, loader, c, sized)
+	backoff := r.retryBackoff
+	for i := 0; i < r.retries && errors.As(err, &ErrFetchFailed{}); i++ {
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, 0, ctx.Err()
+		}
+		backoff *= 2
+		nd, size, err = r.loadOnce(ctx, loader, c, sized)
+	}
+	if err == nil && budgeted {
+		if err := SpendBudget(ctx, size); err != nil {
+			return nil, 0, err
+		}
+	}
+	return nd, size, err
+}
+
+func (r *Resolver) loadOnce(ctx context.Context, loader *blockLoader, c cid.Cid, sized bool) (ipld.Node, int, error) {
+	nd, size, err := r.loadWithTimeout(ctx, loader, c, sized)
+	if err != nil && ctx.Err() == nil && !isNotFound(err) {
+		return nil, 0, ErrFetchFailed{Cid: c, Err: err}
+	}
+	return nd, size, err
+}
+
+func (r *Resolver) loadWithTimeout(ctx context.Context, loader *blockLoader, c cid.Cid, sized bool) (ipld.Node, int, error) {
+	if r.hopTimeout <= 0 {
+		return loader.load(ctx, c, sized)
+	}
+
+	// fetcher sessions fetch blocks with the context they were created with,
+	// so the block is fetched within a session of its own
+	hopCtx, cancel := context.WithTimeout(ctx, r.hopTimeout)
+	if hops, ok := ctx.Value(hopsKey{}).(*hopContexts); ok {
+		// the node may load more blocks through the session until the walk
+		// is done with it
+		hops.cancels = append(hops.cancels, cancel)
+	} else {
+		defer cancel()
+	}
+	hopLoader := r.newBlockLoader(hopCtx)
+	hopLoader.sem = loader.sem
+	nd, size, err := hopLoader.load(hopCtx, c, sized)
+	if err != nil && ctx.Err() == nil && errors.Is(hopCtx.Err(), context.DeadlineExceeded) {
+		return nil, 0, ErrHopTimeout
+	}
+	return nd, size, err
+}
+
+// byteLimitKey is the context key of the byteLimit of a walk.
+type byteLimitKey struct{}
+
+// byteLimit counts the bytes fetched by a walk against the limit configured
+// with WithMaxResolveBytes, including those of the blocks its nodes are
+// spread over, such as the shards of sharded directories.
+type byteLimit struct {
+	max   int
+	total int
+}
+
+// add counts n more bytes, failing with ErrResolveBudgetExceeded once the
+// limit is exceeded.
+func (b *byteLimit) add(n int) error {
+	b.total += n
+	if b.total > b.max {
+		return ErrResolveBudgetExceeded
+	}
+	return nil
+}
+
+// hopsKey is the context key of the hopContexts of a walk.
+type hopsKey struct{}
+
+// hopContexts holds the cancel functions of the contexts blocks are fetched
+// with during a walk (see WithPerHopTimeout). The nodes reified from those
+// blocks load the blocks they are spread over, such as the shards of sharded
+// directories, with the same contexts.
+type hopContexts struct {
+	cancels []context.CancelFunc
+}
+
+// withHopContexts returns a copy of ctx carrying hopContexts, and a function
+// cancelling them. If ctx already carries hopContexts, it is returned as is,
+// and the function does nothing: they are cancelled by whoever attached them.
+func withHopContexts(ctx context.Context) (context.Context, func()) {
+	if _, ok := ctx.Value(hopsKey{}).(*hopContexts); ok {
+		return ctx, func() {}
+	}
+	hops := &hopContexts{}
+	return context.WithValue(ctx, hopsKey{}, hops), func() {
+		for _, cancel := range hops.cancels {
+			cancel()
+		}
+	}
+}
+
+// blockLoader fetches blocks for a single resolution, from the resolver's
+// fetcher factory, falling back to its fallback factory (if any) when a block
+// is not found. Sessions are only created once needed.
+//
+// The blocks of the factories whose node reifier is known are read with the
+// LinkSystem of their session, so that their raw data can be measured: the
+// session is given a reifier recording its LinkSystem along with its first
+// block, and the loader reifies nodes itself.
+type blockLoader struct {
+	ctx       context.Context
+	factories []fetcher.Factory
+	// reifiers are the node reifiers of the factories, nil for those whose
+	// node reifier is not known
+	reifiers []ipld.NodeReifier
+
+	mu       sync.Mutex
+	sessions []fetcher.Fetcher
+	// readers read raw blocks from the storage of the sessions, as recorded
+	// along with their LinkSystems
+	readers []ipld.BlockReadOpener
+	// linkSystems are the LinkSystems recorded from the sessions, with the
+	// node reifiers of their factories, to load the blocks the reified
+	// nodes are spread over (such as the shards of sharded directories):
+	// these are fetched within sem, and count in the ShardHops of the
+	// ResolveStats of the context they are loaded with, if any
+	linkSystems []*ipld.LinkSystem
+
+	// sem, if not nil, bounds the number of concurrent fetches
+	sem chan struct{}
+}
+
+func (r *Resolver) newBlockLoader(ctx context.Context) *blockLoader {
+	factories := []fetcher.Factory{r.FetcherFactory}
+	if r.fallbackFactory != nil {
+		factories = append(factories, r.fallbackFactory)
+	}
+	l := &blockLoader{
+		ctx:         ctx,
+		factories:   factories,
+		reifiers:    make([]ipld.NodeReifier, len(factories)),
+		sessions:    make([]fetcher.Fetcher, len(factories)),
+		readers:     make([]ipld.BlockReadOpener, len(factories)),
+		linkSystems: make([]*ipld.LinkSystem, len(factories)),
+	}
+	for i, factory := range factories {
+		if reifier, ok := r.nodeReifier(factory); ok {
+			l.reifiers[i] = reifier
+			factories[i] = factory.(reifierFactory).WithReifier(l.recordLinkSystem(i))
+		}
+	}
+	if r.maxFetches > 0 {
+		l.sem = make(chan struct{}, r.maxFetches)
+	}
+	return l
+}
+
+// forPath returns the resolver to resolve fpath with: for /ipld/ paths, the
+// raw copy of r.
+func (r *Resolver) forPath(fpath path.Path) *Resolver {
+	if ns, _ := fpath.Namespace(); ns != "ipld" {
+		return r
+	}
+	return r.raw()
+}
+
+// raw returns a copy of r that neither reifies nodes nor follows symlinks or
+// Metadata nodes.
+func (r *Resolver) raw() *Resolver {
+	raw := *r
+	raw.reifier = rawReifier
+	raw.rawNodes = true
+	raw.followSymlinks = false
+	raw.stopAtSymlinks = false
+	raw.batchLoader = r.rawBatchLoader
+	raw.rawBatchLoader = nil
+	return &raw
+}
+
+// rawReifier leaves nodes as decoded by their codec.
+func rawReifier(_ ipld.LinkContext, nd ipld.Node, _ *ipld.LinkSystem) (ipld.Node, error) {
+	return nd, nil
+}
+
+// reifierFactory is a fetcher factory whose node reifier can be changed, such
+// as bsfetcher.FetcherConfig.
+type reifierFactory interface {
+	fetcher.Factory
+	WithReifier(ipld.NodeReifier) fetcher.Factory
+}
+
+// nodeReifier returns the node reifier to reify the nodes of factory with, if
+// it is known and can be changed: the resolver's node reifier, if any, or
+// else that of a bsfetcher or LinkSystem factory.
+func (r *Resolver) nodeReifier(factory fetcher.Factory) (ipld.NodeReifier, bool) {
+	if _, ok := factory.(reifierFactory); !ok {
+		return nil, false
+	}
+	reifier := r.reifier
+	if reifier == nil {
+		switch f := factory.(type) {
+		case bsfetcher.FetcherConfig:
+			reifier = f.NodeReifier
+		case linkSystemFetcher:
+			reifier = f.lsys.NodeReifier
+		default:
+			return nil, false
+		}
+	}
+	if reifier == nil {
+		return rawReifier, true
+	}
+	return reifier, true
+}
+
+// withReifier returns factory with the resolver's node reifier, if any.
+func (r *Resolver) withReifier(factory fetcher.Factory) fetcher.Factory {
+	if r.reifier == nil {
+		return factory
+	}
+	if rf, ok := factory.(reifierFactory); ok {
+		return rf.WithReifier(r.reifier)
+	}
+	return factory
+}
+
+// recordLinkSystem returns the node reifier of the session of the i-th
+// factory, which leaves nodes as decoded, but records the LinkSystem they are
+// loaded with.
+func (l *blockLoader) recordLinkSystem(i int) ipld.NodeReifier {
+	return func(_ ipld.LinkContext, nd ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.linkSystems[i] == nil {
+			read := lsys.StorageReadOpener
+			recorded := *lsys
+			recorded.NodeReifier = l.reifiers[i]
+			recorded.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+				ctx := lnkCtx.Ctx
+				if ctx == nil {
+					ctx = context.Background()
+				}
+				if stats, ok := ctx.Value(statsKey{}).(*ResolveStats); ok {
+					stats.ShardHops++
+				}
+				release, err := l.acquire(ctx)
+				if err != nil {
+					return nil, err
+				}
+				defer release()
+				limit, limited := ctx.Value(byteLimitKey{}).(*byteLimit)
+				budgeted := hasBudget(ctx)
+				if !limited && !budgeted {
+					return read(lnkCtx, lnk)
+				}
+
+				// the block is measured to count towards the limit of the walk
+				// loading it, and drawn from the budget of its context
+				rd, err := read(lnkCtx, lnk)
+				if err != nil {
+					return nil, err
+				}
+				raw, err := io.ReadAll(rd)
+				if err != nil {
+					return nil, err
+				}
+				if limited {
+					if err := limit.add(len(raw)); err != nil {
+						return nil, err
+					}
+				}
+				if budgeted {
+					if err := SpendBudget(ctx, len(raw)); err != nil {
+						return nil, err
+					}
+				}
+				return bytes.NewReader(raw), nil
+			}
+			l.readers[i] = read
+			l.linkSystems[i] = &recorded
+		}
+		return nd, nil
+	}
+}
+
+// load loads the block c, and returns its root node along with the size of
+// its raw data if sized is true. Blockstores key blocks by their whole CID, so
+// a block not found under c is looked up under the other version of c, if
+// any: the same dag-pb block may be addressed with a CIDv0 or a CIDv1.
+func (l *blockLoader) load(ctx context.Context, c cid.Cid, sized bool) (ipld.Node, int, error) {
+	nd, size, err := l.loadCid(ctx, c, sized)
+	if err == nil || !isNotFound(err) {
+		return nd, size, err
+	}
+	if other, ok := otherVersion(c); ok {
+		if nd, size, otherErr := l.loadCid(ctx, other, sized); otherErr == nil {
+			return nd, size, nil
+		}
+	}
+	return nil, 0, err
+}
+
+// otherVersion returns the CIDv1 of the CIDv0 c, or the CIDv0 of c if it is a
+// CIDv1 that can be expressed as one.
+func otherVersion(c cid.Cid) (cid.Cid, bool) {
+	if c.Version() == 0 {
+		return cid.NewCidV1(cid.DagProtobuf, c.Hash()), true
+	}
+	pref := c.Prefix()
+	if pref.Codec != cid.DagProtobuf || pref.MhType != multihash.SHA2_256 || pref.MhLength != 32 {
+		return cid.Cid{}, false
+	}
+	return cid.NewCidV0(c.Hash()), true
+}
+
+func (l *blockLoader) loadCid(ctx context.Context, c cid.Cid, sized bool) (ipld.Node, int, error) {
+	var errs []error
+	for i := range l.factories {
+		nd, size, err := l.fetch(ctx, i, c, sized)
+		if err == nil {
+			return nd, size, nil
+		}
+		errs = append(errs, err)
+		if !isNotFound(err) {
+			break
+		}
+	}
+	if len(errs) == 1 {
+		return nil, 0, errs[0]
+	}
+	return nil, 0, &FallbackError{Primary: errs[0], Secondary: errs[1]}
+}
+
+// fetch fetches the block c with the session of the i-th factory. If sized is
+// true, the size of the raw data of the block is returned along with its root
+// node; blocks of factories whose node reifier is not known are measured by
+// encoding their root node, as their raw data cannot be read.
+func (l *blockLoader) fetch(ctx context.Context, i int, c cid.Cid, sized bool) (ipld.Node, int, error) {
+	nd, size, lsys, err := l.fetchDecoded(ctx, i, c, sized)
+	if err != nil || lsys == nil {
+		return nd, size, err
+	}
+	// reifiers may load blocks themselves, so nodes are reified once the
+	// fetch is over
+	nd, err = lsys.NodeReifier(ipld.LinkContext{Ctx: ctx}, nd, lsys)
+	return nd, size, err
+}
+
+// fetchDecoded fetches the block c for fetch, once the number of concurrent
+// fetches allows it. If its root node is still to be reified, the LinkSystem
+// to reify it with is returned along with it.
+func (l *blockLoader) fetchDecoded(ctx context.Context, i int, c cid.Cid, sized bool) (ipld.Node, int, *ipld.LinkSystem, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer release()
+
+	lnk := cidlink.Link{Cid: c}
+	session := l.session(i)
+	read, lsys := l.linkSystem(i)
+	if lsys == nil {
+		nd, err := fetcherhelpers.Block(ctx, session, lnk)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		read, lsys = l.linkSystem(i)
+		if lsys == nil {
+			if !sized {
+				return nd, 0, nil, nil
+			}
+			size, err := encodedSize(c, nd)
+			return nd, size, nil, err
+		}
+
+		// the first block of the session was loaded to record its
+		// LinkSystem, so it is read again to be measured
+		size := 0
+		if sized {
+			raw, err := readBlock(ctx, read, lnk)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			size = len(raw)
+		}
+		return nd, size, lsys, nil
+	}
+
+	proto, err := session.PrototypeFromLink(lnk)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	raw, err := readBlock(ctx, read, lnk)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	// decode (and verify, unless the storage is trusted) the data read
+	decoder := *lsys
+	decoder.StorageReadOpener = func(ipld.LinkContext, ipld.Link) (io.Reader, error) {
+		return bytes.NewReader(raw), nil
+	}
+	nb := proto.NewBuilder()
+	if err := decoder.Fill(ipld.LinkContext{Ctx: ctx}, lnk, nb); err != nil {
+		return nil, 0, nil, err
+	}
+	return nb.Build(), len(raw), lsys, nil
+}
+
+// acquire waits for the number of concurrent fetches to allow another one,
+// and returns the function to call once it is over.
+func (l *blockLoader) acquire(ctx context.Context) (func(), error) {
+	if l.sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return func() { <-l.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readBlock reads the raw data of the block lnk with read.
+func readBlock(ctx context.Context, read ipld.BlockReadOpener, lnk ipld.Link) ([]byte, error) {
+	rd, err := read(ipld.LinkContext{Ctx: ctx}, lnk)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+// session returns the session of the i-th factory, creating it if needed.
+func (l *blockLoader) session(i int) fetcher.Fetcher {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions[i] == nil {
+		l.sessions[i] = l.factories[i].NewSession(l.ctx)
+	}
+	return l.sessions[i]
+}
+
+// linkSystem returns the reader and LinkSystem recorded from the session of
+// the i-th factory, or nil if none were.
+func (l *blockLoader) linkSystem(i int) (ipld.BlockReadOpener, *ipld.LinkSystem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.readers[i], l.linkSystems[i]
+}
+
+// isNoSuchLink reports whether err, returned by looking a path segment up in a
+// node, means the node has no such link, rather than that a block the node
+// is spread over (such as a shard of a sharded directory) failed to load.
+func isNoSuchLink(err error) bool {
+	return errors.As(err, &schema.ErrNoSuchField{}) ||
+		errors.As(err, &ipld.ErrNotExists{}) ||
+		errors.As(err, &ipld.ErrWrongKind{}) ||
+		errors.As(err, &ipld.ErrInvalidSegmentForList{})
+}
+
+// lookupError returns the error to report when looking a path segment up in
+// a node of the block c fails with err, for another reason than the node
+// having no such link: out of range list indexes and missing blocks are
+// reported as they are, and other failures to load the blocks the node is
+// spread over as an ErrFetchFailed, like failures to fetch c itself.
+func lookupError(ctx context.Context, c cid.Cid, err error) error {
+	if errors.Is(err, ErrIndexOutOfRange) || errors.Is(err, ErrResolveBudgetExceeded) || errors.As(err, &ErrFetchFailed{}) || isNotFound(err) {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return ErrFetchFailed{Cid: c, Err: err}
+}
+
+// isNotFound reports whether err means a block could not be found.
+func isNotFound(err error) bool {
+	return errors.Is(err, format.ErrNotFound) ||
+		errors.Is(err, blockservice.ErrNotFound) ||
+		errors.Is(err, blockstore.ErrNotFound)
+}
+
+// encodedSize returns the size of the block root nd once encoded with the
+// codec of c.
+func encodedSize(c cid.Cid, nd ipld.Node) (int, error) {
+	// reified nodes (such as UnixFS directories) are encoded through their
+	// substrate
+	if tn, ok := nd.(schema.TypedNode); ok {
+		nd = tn.Representation()
+	}
+	encode, err := multicodec.LookupEncoder(c.Prefix().Codec)
+	if err != nil {
+		return 0, err
+	}
+	var w countingWriter
+	if err := encode(nd, &w); err != nil {
+		return 0, err
+	}
+	return w.n, nil
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
 }
 
 func pathAllSelector(path []string) ipld.Node {
@@ -3,18 +3,32 @@ package resolver_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-fetcher"
+	fetcherhelpers "github.com/ipfs/go-fetcher/helpers"
 	bsfetcher "github.com/ipfs/go-fetcher/impl/blockservice"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/schema"
 	"github.com/multiformats/go-multihash"
@@ -23,7 +37,10 @@ import (
 	dagmock "github.com/ipfs/go-merkledag/test"
 	path "github.com/ipfs/go-path"
 	"github.com/ipfs/go-path/resolver"
+	"github.com/ipfs/go-unixfs/hamt"
 	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
 	dagcbor "github.com/ipld/go-ipld-prime/codec/dagcbor"
 	dagjson "github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/stretchr/testify/assert"
@@ -187,6 +204,45 @@ func TestResolveToLastNode_ErrNoLink(t *testing.T) {
 	require.EqualError(t, err, resolver.ErrNoLink{Name: "apples", Node: bKey}.Error())
 }
 
+func TestResolvePath_ErrNoLink(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	cases := []struct {
+		segments []string
+		name     string
+		node     cid.Cid
+	}{
+		{[]string{a.Cid().String(), "cheese", "time"}, "cheese", a.Cid()},
+		{[]string{a.Cid().String(), "child", "apples"}, "apples", b.Cid()},
+	}
+	for _, c := range cases {
+		p, err := path.FromSegments("/ipfs/", c.segments...)
+		require.NoError(t, err)
+
+		_, _, err = r.ResolvePath(ctx, p)
+		var errNoLink resolver.ErrNoLink
+		require.True(t, errors.As(err, &errNoLink), "ResolvePath(%s) returned %v", p, err)
+		assert.Equal(t, c.name, errNoLink.Name)
+		assert.Equal(t, c.node, errNoLink.Node)
+
+		_, _, err = r.ResolveToLastNode(ctx, p)
+		require.True(t, errors.As(err, &errNoLink), "ResolveToLastNode(%s) returned %v", p, err)
+		assert.Equal(t, c.name, errNoLink.Name)
+		assert.Equal(t, c.node, errNoLink.Node)
+	}
+}
+
 func TestResolveToLastNode_NoUnnecessaryFetching(t *testing.T) {
 	ctx := context.Background()
 	bsrv := dagmock.Bserv()
@@ -223,6 +279,183 @@ func TestResolveToLastNode_NoUnnecessaryFetching(t *testing.T) {
 	require.Equal(t, b.Cid(), resolvedCID)
 }
 
+// countingCodec is a private use multicodec encoded as dag-cbor, counting the
+// blocks it decodes.
+const countingCodec = 0x300001
+
+var countingDecodes int32
+
+func init() {
+	multicodec.RegisterEncoder(countingCodec, dagcbor.Encode)
+	multicodec.RegisterDecoder(countingCodec, func(na ipld.NodeAssembler, r io.Reader) error {
+		atomic.AddInt32(&countingDecodes, 1)
+		return dagcbor.Decode(na, r)
+	})
+}
+
+func TestResolveToLastNode_NoFinalDecode(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	addBlock := func(codec uint64, dagJSON string) cid.Cid {
+		nb := basicnode.Prototype.Any.NewBuilder()
+		require.NoError(t, dagjson.Decode(nb, strings.NewReader(dagJSON)))
+		out := new(bytes.Buffer)
+		require.NoError(t, dagcbor.Encode(nb.Build(), out))
+		c, err := cid.Prefix{
+			Version:  1,
+			Codec:    codec,
+			MhType:   multihash.SHA2_256,
+			MhLength: 32,
+		}.Sum(out.Bytes())
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(out.Bytes(), c)
+		require.NoError(t, err)
+		require.NoError(t, bsrv.AddBlock(ctx, blk))
+		return c
+	}
+	leaf := addBlock(countingCodec, `{"large": "leaf"}`)
+	root := addBlock(cid.DagCBOR, `{"leaf": {"/": "`+leaf.String()+`"}}`)
+
+	r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv))
+	p := path.FromString(root.String() + "/leaf")
+
+	atomic.StoreInt32(&countingDecodes, 0)
+	c, remainder, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, leaf, c)
+	assert.Empty(t, remainder)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&countingDecodes))
+
+	// resolving the node itself decodes it
+	_, _, err = r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&countingDecodes))
+}
+
+func TestResolveToLastNodeLink(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child")
+	require.NoError(t, err)
+	lnk, rest, err := r.ResolveToLastNodeLink(ctx, p)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	require.IsType(t, cidlink.Link{}, lnk)
+	assert.Equal(t, b.Cid(), lnk.(cidlink.Link).Cid)
+
+	_, _, err = r.ResolveToLastNodeLink(ctx, path.FromString(p.String()+"/nonexistent"))
+	var noLink resolver.ErrNoLink
+	assert.True(t, errors.As(err, &noLink), "expected ErrNoLink, got %v", err)
+}
+
+func TestResolveToLastNodeWithPrototype(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	raw := merkledag.NewRawNode([]byte("hello"))
+	require.NoError(t, a.AddNodeLink("child", b))
+	require.NoError(t, a.AddNodeLink("raw", raw))
+	for _, n := range []format.Node{a, b, raw} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	decode := func(segs ...string) (cid.Cid, ipld.Node) {
+		p, err := path.FromSegments("/ipfs/", append([]string{a.Cid().String()}, segs...)...)
+		require.NoError(t, err)
+		c, proto, err := r.ResolveToLastNodeWithPrototype(ctx, p)
+		require.NoError(t, err)
+		blk, err := bsrv.GetBlock(ctx, c)
+		require.NoError(t, err)
+		decoder, err := multicodec.LookupDecoder(c.Prefix().Codec)
+		require.NoError(t, err)
+		nb := proto.NewBuilder()
+		require.NoError(t, decoder(nb, bytes.NewReader(blk.RawData())))
+		return c, nb.Build()
+	}
+
+	c, nd := decode("child")
+	assert.Equal(t, b.Cid(), c)
+	pbnd, ok := nd.(dagpb.PBNode)
+	require.True(t, ok, "expected a dag-pb node, got %T", nd)
+	assert.Equal(t, b.Data(), pbnd.FieldData().Must().Bytes())
+
+	c, nd = decode("raw")
+	assert.Equal(t, raw.Cid(), c)
+	data, err := nd.AsBytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	c, nd = decode()
+	assert.Equal(t, a.Cid(), c)
+	pbnd, ok = nd.(dagpb.PBNode)
+	require.True(t, ok, "expected a dag-pb node, got %T", nd)
+	assert.Equal(t, int64(2), pbnd.FieldLinks().Length())
+
+	// a path ending within a block has no prototype of its own
+	p, err := path.FromSegments("/ipld/", a.Cid().String(), "Links", "0")
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNodeWithPrototype(ctx, p)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ends within block")
+}
+
+func TestResolveToLastNode_CidVersions(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, sub.AddNodeLink("file", file))
+	root := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, root.AddNodeLink("sub", sub))
+	for _, n := range []format.Node{file, sub, root} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	v0 := root.Cid()
+	require.Equal(t, uint64(0), v0.Version())
+	v1 := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	for _, c := range []cid.Cid{v0, v1} {
+		for _, txt := range []string{c.String() + "/sub/file", "/ipfs/" + c.String() + "/sub/file"} {
+			p, err := path.ParsePath(txt)
+			require.NoError(t, err, txt)
+			last, remainder, err := r.ResolveToLastNode(ctx, p)
+			require.NoError(t, err, txt)
+			assert.Equal(t, file.Cid(), last, txt)
+			assert.Empty(t, remainder, txt)
+		}
+
+		// a path ending at the root resolves to the root as given
+		last, remainder, err := r.ResolveToLastNode(ctx, path.FromCid(c))
+		require.NoError(t, err)
+		assert.Equal(t, c, last)
+		assert.Empty(t, remainder)
+	}
+
+	// blocks stored under their CIDv1 are found through their CIDv0
+	other := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, other.AddNodeLink("file", file))
+	blk, err := blocks.NewBlockWithCid(other.RawData(), cid.NewCidV1(cid.DagProtobuf, other.Cid().Hash()))
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+	_, _, err = r.ResolveToLastNode(ctx, path.FromString(other.Cid().String()+"/file"))
+	require.NoError(t, err)
+}
+
 func TestPathRemainder(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -254,6 +487,87 @@ func TestPathRemainder(t *testing.T) {
 	require.Equal(t, "foo/bar", path.Join(remainder))
 }
 
+func TestPathRemainder_DagJSON(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, dagjson.Decode(nb, strings.NewReader(`{"foo": {"bar": "baz"}, "list": [{"a": 1}]}`)))
+	out := new(bytes.Buffer)
+	require.NoError(t, dagjson.Encode(nb.Build(), out))
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    0x0129, // dag-json
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+	r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv))
+
+	// same as with dag-cbor
+	rp1, remainder, err := r.ResolveToLastNode(ctx, path.FromString(lnk.String()+"/foo/bar"))
+	require.NoError(t, err)
+	assert.Equal(t, lnk, rp1)
+	require.Equal(t, "foo/bar", path.Join(remainder))
+
+	nd, _, err := r.ResolvePath(ctx, path.FromString(lnk.String()+"/foo/bar"))
+	require.NoError(t, err)
+	s, err := nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "baz", s)
+
+	nd, _, err = r.ResolvePath(ctx, path.FromString(lnk.String()+"/list/0/a"))
+	require.NoError(t, err)
+	i, err := nd.AsInt()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), i)
+}
+
+func TestPathRemainderPath(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, dagjson.Decode(nb, strings.NewReader(`{"foo": {"bar": "baz", "a/b": "c"}}`)))
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+	r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv))
+
+	c, remainder, err := r.ResolveToLastNodeRemainderPath(ctx, path.FromString(lnk.String()+"/foo/bar"))
+	require.NoError(t, err)
+	assert.Equal(t, lnk, c)
+	assert.Equal(t, "foo/bar", remainder.String())
+	assert.True(t, remainder.IsRelative())
+
+	// the remainder composes with the path it was resolved from
+	joined, err := path.ResolveRelative(path.FromCid(c), remainder.String())
+	require.NoError(t, err)
+	assert.Equal(t, path.FromString("/ipfs/"+lnk.String()+"/foo/bar"), joined)
+
+	_, remainder, err = r.ResolveToLastNodeRemainderPath(ctx, path.FromCid(lnk))
+	require.NoError(t, err)
+	assert.Equal(t, "", remainder.String())
+
+	// segments are written back escaped
+	r = resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv), resolver.WithEscapedSegments())
+	_, remainder, err = r.ResolveToLastNodeRemainderPath(ctx, path.FromString(lnk.String()+`/foo/a\/b`))
+	require.NoError(t, err)
+	assert.Equal(t, `foo/a\/b`, remainder.String())
+}
+
 func TestResolveToLastNode_MixedSegmentTypes(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -291,3 +605,2609 @@ func TestResolveToLastNode_MixedSegmentTypes(t *testing.T) {
 	assert.Equal(t, 0, len(remainder))
 	assert.True(t, cid.Equals(a.Cid()))
 }
+
+func newUnixFSFetcherFactory(bsrv blockservice.BlockService) bsfetcher.FetcherConfig {
+	fetcherFactory := bsfetcher.NewFetcherConfig(bsrv)
+	fetcherFactory.PrototypeChooser = dagpb.AddSupportToChooser(func(lnk ipld.Link, lnkCtx ipld.LinkContext) (ipld.NodePrototype, error) {
+		if tlnkNd, ok := lnkCtx.LinkNode.(schema.TypedLinkNode); ok {
+			return tlnkNd.LinkTargetNodePrototype(), nil
+		}
+		return basicnode.Prototype.Any, nil
+	})
+	fetcherFactory.NodeReifier = unixfsnode.Reify
+	return fetcherFactory
+}
+
+// addChain adds a chain of n+1 nodes to bsrv, each linking to the next one
+// with a link named "child", and returns the root.
+func addChain(ctx context.Context, t *testing.T, bsrv blockservice.BlockService, n int) *merkledag.ProtoNode {
+	node := randNode()
+	require.NoError(t, bsrv.AddBlock(ctx, node))
+	for i := 0; i < n; i++ {
+		parent := randNode()
+		require.NoError(t, parent.AddNodeLink("child", node))
+		require.NoError(t, bsrv.AddBlock(ctx, parent))
+		node = parent
+	}
+	return node
+}
+
+func chainPath(t *testing.T, root *merkledag.ProtoNode, n int) path.Path {
+	segments := []string{root.Cid().String()}
+	for i := 0; i < n; i++ {
+		segments = append(segments, "child")
+	}
+	p, err := path.FromSegments("/ipfs/", segments...)
+	require.NoError(t, err)
+	return p
+}
+
+func TestResolveMaxDepth(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	root := addChain(ctx, t, bsrv, 100)
+
+	fetcherFactory := newUnixFSFetcherFactory(bsrv)
+	unlimited := resolver.NewBasicResolver(fetcherFactory)
+	limited := resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxDepth(10))
+
+	_, _, err := unlimited.ResolvePath(ctx, chainPath(t, root, 100))
+	require.NoError(t, err)
+
+	_, _, err = limited.ResolvePath(ctx, chainPath(t, root, 10))
+	require.NoError(t, err)
+
+	_, _, err = limited.ResolvePath(ctx, chainPath(t, root, 11))
+	require.ErrorIs(t, err, resolver.ErrPathTooDeep)
+
+	_, _, err = limited.ResolvePath(ctx, chainPath(t, root, 100))
+	require.ErrorIs(t, err, resolver.ErrPathTooDeep)
+
+	_, _, err = limited.ResolveToLastNode(ctx, chainPath(t, root, 100))
+	require.ErrorIs(t, err, resolver.ErrPathTooDeep)
+
+	_, err = limited.ResolvePathComponents(ctx, chainPath(t, root, 100))
+	require.ErrorIs(t, err, resolver.ErrPathTooDeep)
+}
+
+func TestResolveToLastNodeWithStats(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	p, err := path.FromSegments("/ipfs/", a.Cid().String())
+	require.NoError(t, err)
+	rCid, rest, stats, err := r.ResolveToLastNodeWithStats(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, a.Cid(), rCid)
+	assert.Empty(t, rest)
+	assert.Equal(t, resolver.ResolveStats{}, stats)
+
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child")
+	require.NoError(t, err)
+	rCid, rest, stats, err = r.ResolveToLastNodeWithStats(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, b.Cid(), rCid)
+	assert.Empty(t, rest)
+	assert.Equal(t, resolver.ResolveStats{BlocksFetched: 1, Bytes: len(a.RawData())}, stats)
+
+	// the grandchild itself is not fetched
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	rCid, rest, stats, err = r.ResolveToLastNodeWithStats(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, c.Cid(), rCid)
+	assert.Empty(t, rest)
+	assert.Equal(t, resolver.ResolveStats{BlocksFetched: 2, Bytes: len(a.RawData()) + len(b.RawData())}, stats)
+}
+
+func TestResolveToLastNodeWithStats_DagCBOR(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, strings.NewReader(`{"foo": {"bar": "baz"}}`))
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	err = dagcbor.Encode(nb.Build(), out)
+	require.NoError(t, err)
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+	r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv))
+
+	rCid, remainder, stats, err := r.ResolveToLastNodeWithStats(ctx, path.FromString(lnk.String()+"/foo/bar"))
+	require.NoError(t, err)
+	assert.Equal(t, lnk, rCid)
+	assert.Equal(t, []string{"foo", "bar"}, remainder)
+	assert.Equal(t, resolver.ResolveStats{BlocksFetched: 1, Bytes: len(blk.RawData())}, stats)
+}
+
+func TestResolveStatsRawBlockSize(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	// blocks are measured as stored, not as their codec would encode them
+	addJSON := func(data string) cid.Cid {
+		lnk, err := cid.Prefix{
+			Version:  1,
+			Codec:    0x0129, // dag-json
+			MhType:   multihash.SHA2_256,
+			MhLength: 32,
+		}.Sum([]byte(data))
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid([]byte(data), lnk)
+		require.NoError(t, err)
+		require.NoError(t, bsrv.AddBlock(ctx, blk))
+		return lnk
+	}
+	child := `{  "bar" :  "baz"  }`
+	childCid := addJSON(child)
+	root := `{ "child" : { "/" : "` + childCid.String() + `" } }`
+	rootCid := addJSON(root)
+	p := path.FromString(rootCid.String() + "/child/bar")
+	total := len(root) + len(child)
+
+	for _, opts := range [][]resolver.Option{nil, {resolver.WithPerHopTimeout(time.Minute)}} {
+		r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv), opts...)
+		_, _, stats, err := r.ResolveToLastNodeWithStats(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, resolver.ResolveStats{BlocksFetched: 2, Bytes: total}, stats)
+
+		steps, err := r.ResolvePathSteps(ctx, p)
+		require.NoError(t, err)
+		require.Len(t, steps, 3)
+		assert.Equal(t, len(root), steps[0].BlockSize)
+		assert.Equal(t, len(child), steps[1].BlockSize)
+
+		_, _, err = r.ResolvePath(resolver.ContextWithBudget(ctx, total), p)
+		assert.NoError(t, err)
+		_, _, err = r.ResolvePath(resolver.ContextWithBudget(ctx, total-1), p)
+		assert.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+	}
+}
+
+type countingBlockstore struct {
+	blockstore.Blockstore
+	gets int32
+}
+
+func (bs *countingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	atomic.AddInt32(&bs.gets, 1)
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func (bs *countingBlockstore) Gets() int {
+	return int(atomic.LoadInt32(&bs.gets))
+}
+
+func newCountingBserv() (blockservice.BlockService, *countingBlockstore) {
+	bstore := &countingBlockstore{Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))}
+	return blockservice.New(bstore, offline.Exchange(bstore)), bstore
+}
+
+func TestResolveCancelledContext(t *testing.T) {
+	bsrv, bstore := newCountingBserv()
+	root := addChain(context.Background(), t, bsrv, 2)
+	p := chainPath(t, root, 2)
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.Equal(t, 0, bstore.Gets())
+}
+
+func TestResolveLinks(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	root := addChain(ctx, t, bsrv, 3)
+
+	fetcherFactory := newUnixFSFetcherFactory(bsrv)
+	r := resolver.NewBasicResolver(fetcherFactory)
+
+	rootNode, err := fetcherhelpers.Block(ctx, fetcherFactory.NewSession(ctx), cidlink.Link{Cid: root.Cid()})
+	require.NoError(t, err)
+
+	names := []string{"child", "child", "child"}
+	nodes, err := r.ResolveLinks(ctx, rootNode, names)
+	require.NoError(t, err)
+	require.Len(t, nodes, len(names)+1)
+	assert.Equal(t, rootNode, nodes[0])
+
+	// the last node is the end of the chain, which has no more links
+	last, ok := nodes[len(nodes)-1].(unixfsnode.PathedPBNode)
+	require.True(t, ok)
+	assert.Equal(t, int64(0), last.FieldLinks().Length())
+}
+
+func TestResolvePathWalk(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+
+	var cids []cid.Cid
+	var names []string
+	err = r.ResolvePathWalk(ctx, p, func(c cid.Cid, name string) error {
+		cids = append(cids, c)
+		names = append(names, name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{b.Cid(), c.Cid()}, cids)
+	assert.Equal(t, []string{"child", "grandchild"}, names)
+
+	// abort after the first hop
+	errStop := errors.New("stop")
+	calls := 0
+	err = r.ResolvePathWalk(ctx, p, func(c cid.Cid, name string) error {
+		calls++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+
+	// missing segment
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "missing")
+	require.NoError(t, err)
+	err = r.ResolvePathWalk(ctx, p, func(c cid.Cid, name string) error { return nil })
+	var errNoLink resolver.ErrNoLink
+	require.ErrorAs(t, err, &errNoLink)
+	assert.Equal(t, resolver.ErrNoLink{Name: "missing", Node: b.Cid()}, errNoLink)
+}
+
+func TestResolvePathPartial(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	// fails on the second-to-last segment
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "missing", "grandchild")
+	require.NoError(t, err)
+	lastCid, consumed, nd, err := r.ResolvePathPartial(ctx, p)
+	var errNoLink resolver.ErrNoLink
+	require.True(t, errors.As(err, &errNoLink))
+	assert.Equal(t, "missing", errNoLink.Name)
+	assert.Equal(t, b.Cid(), errNoLink.Node)
+	assert.Equal(t, b.Cid(), lastCid)
+	assert.Equal(t, 1, consumed)
+	require.NotNil(t, nd)
+	_, err = nd.LookupByString("grandchild")
+	require.NoError(t, err)
+
+	// fully resolved
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	lastCid, consumed, nd, err = r.ResolvePathPartial(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, c.Cid(), lastCid)
+	assert.Equal(t, 2, consumed)
+	require.NotNil(t, nd)
+}
+
+func unixfsNode(t *testing.T, dataType int64, payload []byte) *merkledag.ProtoNode {
+	fsdata, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, dataType)
+		if payload != nil {
+			builder.Data(b, payload)
+		}
+	})
+	require.NoError(t, err)
+	return merkledag.NodeWithData(data.EncodeUnixFSData(fsdata))
+}
+
+func TestResolveFollowSymlinks(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	up := unixfsNode(t, data.Data_Symlink, []byte("../file"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, sub.AddNodeLink("up", up))
+	root := unixfsNode(t, data.Data_Directory, nil)
+	links := map[string]*merkledag.ProtoNode{
+		"file":    file,
+		"sub":     sub,
+		"link":    unixfsNode(t, data.Data_Symlink, []byte("file")),
+		"link2":   unixfsNode(t, data.Data_Symlink, []byte("link")),
+		"dirlink": unixfsNode(t, data.Data_Symlink, []byte("./sub")),
+		"loop":    unixfsNode(t, data.Data_Symlink, []byte("loop")),
+		"escape":  unixfsNode(t, data.Data_Symlink, []byte("../file")),
+		"abs":     unixfsNode(t, data.Data_Symlink, []byte("/ipfs/"+file.Cid().String())),
+	}
+	for name, nd := range links {
+		require.NoError(t, root.AddNodeLink(name, nd))
+		require.NoError(t, bsrv.AddBlock(ctx, nd))
+	}
+	for _, nd := range []*merkledag.ProtoNode{up, root} {
+		require.NoError(t, bsrv.AddBlock(ctx, nd))
+	}
+
+	fetcherFactory := newUnixFSFetcherFactory(bsrv)
+	r := resolver.NewBasicResolver(fetcherFactory, resolver.WithFollowSymlinks())
+	noFollow := resolver.NewBasicResolver(fetcherFactory)
+
+	p, err := path.FromSegments("/ipfs/", root.Cid().String(), "link")
+	require.NoError(t, err)
+	rCid, rest, err := noFollow.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, links["link"].Cid(), rCid)
+
+	for _, segs := range [][]string{
+		{"link"},
+		{"link2"},
+		{"sub", "up"},
+		{"dirlink", "up"},
+		{"abs"},
+	} {
+		p, err := path.FromSegments("/ipfs/", append([]string{root.Cid().String()}, segs...)...)
+		require.NoError(t, err)
+
+		rCid, rest, err := r.ResolveToLastNode(ctx, p)
+		require.NoError(t, err, p.String())
+		assert.Empty(t, rest)
+		assert.Equal(t, file.Cid(), rCid, p.String())
+
+		nd, lnk, err := r.ResolvePath(ctx, p)
+		require.NoError(t, err, p.String())
+		assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+		assert.NotNil(t, nd)
+	}
+
+	p, err = path.FromSegments("/ipfs/", root.Cid().String(), "loop")
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, resolver.ErrTooManySymlinks)
+
+	p, err = path.FromSegments("/ipfs/", root.Cid().String(), "escape")
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, path.ErrBadPath)
+
+	// dirlink/up is rewritten to sub/up, then to file
+	p, err = path.FromSegments("/ipfs/", root.Cid().String(), "dirlink", "up")
+	require.NoError(t, err)
+	last, consumed, nd, err := r.ResolvePathPartial(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, file.Cid(), last)
+	assert.Equal(t, 1, consumed)
+	assert.NotNil(t, nd)
+
+	var crossed []string
+	err = r.ResolvePathWalk(ctx, p, func(blk cid.Cid, name string) error {
+		crossed = append(crossed, name+"="+blk.String())
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file=" + file.Cid().String()}, crossed)
+
+	_, _, _, err = noFollow.ResolvePathPartial(ctx, p)
+	var noLink resolver.ErrNoLink
+	require.ErrorAs(t, err, &noLink)
+	assert.Equal(t, "up", noLink.Name)
+	err = noFollow.ResolvePathWalk(ctx, p, func(cid.Cid, string) error { return nil })
+	require.ErrorAs(t, err, &noLink)
+}
+
+func TestResolveFollowSymlinksWalksOnce(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, sub.AddNodeLink("file", file))
+	root := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, root.AddNodeLink("sub", sub))
+	for _, nd := range []*merkledag.ProtoNode{file, sub, root} {
+		require.NoError(t, bsrv.AddBlock(ctx, nd))
+	}
+
+	p, err := path.FromSegments("/ipfs/", root.Cid().String(), "sub", "file")
+	require.NoError(t, err)
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithFollowSymlinks())
+
+	// a path crossing no symlink is walked once
+	gets := bstore.Gets()
+	_, _, err = r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, 3, bstore.Gets()-gets)
+
+	// ResolveToLastNode also fetches the last block, to tell whether it is a
+	// symlink, but no block twice
+	gets = bstore.Gets()
+	rCid, rest, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, file.Cid(), rCid)
+	assert.Equal(t, 3, bstore.Gets()-gets)
+}
+
+func TestResolveMaxResolveBytes(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	total := len(a.RawData()) + len(b.RawData()) + len(c.RawData())
+
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	fetcherFactory := newUnixFSFetcherFactory(bsrv)
+
+	r := resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxResolveBytes(total))
+	_, _, err = r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+
+	r = resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxResolveBytes(total-1))
+	_, _, err = r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+
+	// ResolveToLastNode does not fetch the grandchild
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+
+	r = resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxResolveBytes(len(a.RawData())))
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+}
+
+func TestResolveByteLimitsHAMTShard(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	dserv := merkledag.NewDAGService(bsrv)
+
+	leaf := unixfsNode(t, data.Data_File, []byte("hello"))
+	require.NoError(t, dserv.Add(ctx, leaf))
+	shard, err := hamt.NewShard(dserv, 256)
+	require.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), leaf))
+	}
+	root, err := shard.Node()
+	require.NoError(t, err)
+
+	p, err := path.FromSegments("/ipfs/", root.Cid().String(), "entry-1234")
+	require.NoError(t, err)
+	fetcherFactory := newUnixFSFetcherFactory(bsrv)
+	_, _, stats, err := resolver.NewBasicResolver(fetcherFactory).ResolveToLastNodeWithStats(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, len(root.RawData()), stats.Bytes)
+	require.Greater(t, stats.ShardHops, 1)
+
+	// the shards below the root count towards the limit
+	r := resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxResolveBytes(len(root.RawData())+1))
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+	_, _, err = r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+
+	// as are they to the budget of the context
+	unlimited := resolver.NewBasicResolver(fetcherFactory)
+	_, _, err = unlimited.ResolveToLastNode(resolver.ContextWithBudget(ctx, len(root.RawData())+1), p)
+	require.ErrorIs(t, err, resolver.ErrResolveBudgetExceeded)
+	_, _, err = unlimited.ResolveToLastNode(resolver.ContextWithBudget(ctx, 1<<20), p)
+	require.NoError(t, err)
+
+	r = resolver.NewBasicResolver(fetcherFactory, resolver.WithMaxResolveBytes(1<<20))
+	rCid, _, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.Cid(), rCid)
+}
+
+func TestFallbackResolver(t *testing.T) {
+	ctx := context.Background()
+	primary := dagmock.Bserv()
+	secondary := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+
+	// b is only available from the secondary
+	require.NoError(t, primary.AddBlock(ctx, a))
+	require.NoError(t, secondary.AddBlock(ctx, b))
+	require.NoError(t, primary.AddBlock(ctx, c))
+
+	r := resolver.NewFallbackResolver(newUnixFSFetcherFactory(primary), newUnixFSFetcherFactory(secondary))
+
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: c.Cid()}, lnk)
+
+	// a is only available from the primary
+	p, err = path.FromSegments("/ipfs/", a.Cid().String())
+	require.NoError(t, err)
+	_, lnk, err = r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: a.Cid()}, lnk)
+
+	// missing from both
+	missing := randNode()
+	p, err = path.FromSegments("/ipfs/", missing.Cid().String())
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	var fallbackErr *resolver.FallbackError
+	require.True(t, errors.As(err, &fallbackErr))
+	assert.ErrorIs(t, fallbackErr.Primary, blockservice.ErrNotFound)
+	assert.ErrorIs(t, fallbackErr.Secondary, blockservice.ErrNotFound)
+	assert.ErrorIs(t, err, blockservice.ErrNotFound)
+
+	// without fallback, b cannot be found
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	_, _, err = resolver.NewBasicResolver(newUnixFSFetcherFactory(primary)).ResolvePath(ctx, p)
+	require.ErrorIs(t, err, blockservice.ErrNotFound)
+}
+
+func TestResolveToLastNode_HAMTShard(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+	dserv := merkledag.NewDAGService(bsrv)
+
+	leaf := unixfsNode(t, data.Data_File, []byte("hello"))
+	require.NoError(t, dserv.Add(ctx, leaf))
+
+	shard, err := hamt.NewShard(dserv, 256)
+	require.NoError(t, err)
+	const entries = 10000
+	for i := 0; i < entries; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), leaf))
+	}
+	root, err := shard.Node()
+	require.NoError(t, err)
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", root.Cid().String(), "entry-1234")
+	require.NoError(t, err)
+
+	rCid, remainder, stats, err := r.ResolveToLastNodeWithStats(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.Cid(), rCid)
+	assert.Empty(t, remainder)
+
+	// only the shard root counts as fetched; the shards below it along the
+	// path to the entry count as shard hops
+	assert.Equal(t, 1, stats.BlocksFetched)
+	// 10k entries need two levels of 256-wide shards, a third level at most
+	// for colliding hash prefixes
+	assert.LessOrEqual(t, bstore.Gets(), 3)
+}
+
+func TestResolveStatsShardHops(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	dserv := merkledag.NewDAGService(bsrv)
+
+	leaf := unixfsNode(t, data.Data_File, []byte("hello"))
+	other := unixfsNode(t, data.Data_File, []byte("other"))
+	flat := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, flat.AddNodeLink("entry", leaf))
+	for _, n := range []format.Node{leaf, other, flat} {
+		require.NoError(t, dserv.Add(ctx, n))
+	}
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	const entries = 500
+	for i := 0; i < entries; i++ {
+		n := leaf
+		if i%2 == 0 {
+			n = other
+		}
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), n))
+	}
+	shardRoot, err := shard.Node()
+	require.NoError(t, err)
+	require.NoError(t, dserv.Add(ctx, shardRoot))
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	p, err := path.FromSegments("/ipfs/", flat.Cid().String(), "entry")
+	require.NoError(t, err)
+	_, _, stats, err := r.ResolveToLastNodeWithStats(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.ShardHops)
+
+	// 500 entries need more than one level of 16-wide shards
+	deepest := 0
+	for i := 0; i < entries; i += 7 {
+		p, err := path.FromSegments("/ipfs/", shardRoot.Cid().String(), fmt.Sprintf("entry-%d", i))
+		require.NoError(t, err)
+		expected, _, err := r.ResolveToLastNode(ctx, p)
+		require.NoError(t, err)
+		rCid, remainder, stats, err := r.ResolveToLastNodeWithStats(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, expected, rCid, p)
+		assert.Empty(t, remainder)
+		assert.Greater(t, stats.ShardHops, 0, p)
+		assert.Equal(t, 1, stats.BlocksFetched, p)
+		if stats.ShardHops > deepest {
+			deepest = stats.ShardHops
+		}
+	}
+	assert.Greater(t, deepest, 1)
+
+	// shards are counted on the way to a missing segment too
+	p, err = path.FromSegments("/ipfs/", shardRoot.Cid().String(), "entry-1", "missing")
+	require.NoError(t, err)
+	_, _, stats, err = r.ResolveToLastNodeWithStats(ctx, p)
+	var noLink resolver.ErrNoLink
+	require.True(t, errors.As(err, &noLink), "expected ErrNoLink, got %v", err)
+	assert.Greater(t, stats.ShardHops, 0)
+
+	p, err = path.FromSegments("/ipfs/", shardRoot.Cid().String(), "nonexistent")
+	require.NoError(t, err)
+	_, _, _, err = r.ResolveToLastNodeWithStats(ctx, p)
+	require.True(t, errors.As(err, &noLink), "expected ErrNoLink, got %v", err)
+}
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+type fakeSpan struct {
+	name  string
+	attrs map[string]interface{}
+	ended bool
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, name string) (context.Context, resolver.Span) {
+	s := &fakeSpan{name: name, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *fakeSpan) End() {
+	s.ended = true
+}
+
+func TestResolveWithTracer(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	tracer := &fakeTracer{}
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithTracer(tracer))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.NoError(t, err)
+
+	// one span per block fetched
+	require.Len(t, tracer.spans, 3)
+	expected := []struct {
+		segment string
+		c       cid.Cid
+	}{{"", a.Cid()}, {"child", b.Cid()}, {"grandchild", c.Cid()}}
+	for i, span := range tracer.spans {
+		assert.Equal(t, "resolver.fetch", span.name)
+		assert.True(t, span.ended)
+		assert.Equal(t, expected[i].segment, span.attrs["segment"])
+		assert.Equal(t, expected[i].c, span.attrs["cid"])
+		assert.NotContains(t, span.attrs, "error")
+	}
+
+	// a failed fetch records the error
+	tracer.spans = nil
+	missing := randNode()
+	require.NoError(t, a.AddNodeLink("missing", missing))
+	require.NoError(t, bsrv.AddBlock(ctx, a))
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "missing")
+	require.NoError(t, err)
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.Error(t, err)
+	require.Len(t, tracer.spans, 2)
+	assert.Contains(t, tracer.spans[1].attrs, "error")
+}
+
+func TestResolveWithTranscript(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	missing := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, b.AddNodeLink("missing", missing))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	var transcript []resolver.TranscriptEntry
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithTranscript(&transcript))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.NoError(t, err)
+	succeeded := transcript
+	assert.Equal(t, []resolver.TranscriptEntry{
+		{Segment: "", Cid: a.Cid()},
+		{Segment: "child", Cid: b.Cid()},
+		{Segment: "grandchild", Cid: c.Cid()},
+	}, succeeded)
+
+	// the failed resolution diverges at the block that could not be fetched
+	transcript = nil
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "missing")
+	require.NoError(t, err)
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.Error(t, err)
+	failed := transcript
+	require.Len(t, failed, 3)
+	assert.Equal(t, succeeded[:2], failed[:2])
+	assert.Equal(t, "missing", failed[2].Segment)
+	assert.Equal(t, missing.Cid(), failed[2].Cid)
+	assert.ErrorIs(t, err, failed[2].Err)
+}
+
+func TestResolveSingle(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	factory := newUnixFSFetcherFactory(bsrv)
+	r := resolver.NewBasicResolver(factory)
+	nd, err := fetcherhelpers.Block(ctx, factory.NewSession(ctx), cidlink.Link{Cid: a.Cid()})
+	require.NoError(t, err)
+
+	child, lnk, err := r.ResolveSingle(ctx, nd, "child")
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: b.Cid()}, lnk)
+	pbnd, ok := child.(interface{ FieldData() dagpb.MaybeBytes })
+	require.True(t, ok)
+	assert.Equal(t, b.Data(), pbnd.FieldData().Must().Bytes())
+
+	_, _, err = r.ResolveSingle(ctx, nd, "missing")
+	var errNoLink resolver.ErrNoLink
+	require.True(t, errors.As(err, &errNoLink))
+	assert.Equal(t, "missing", errNoLink.Name)
+
+	// failing to load the shard holding name is not a missing link
+	bsrv, root, entry := newFailingShard(t)
+	factory = newUnixFSFetcherFactory(bsrv)
+	r = resolver.NewBasicResolver(factory)
+	nd, err = fetcherhelpers.Block(ctx, factory.NewSession(ctx), cidlink.Link{Cid: root})
+	require.NoError(t, err)
+	_, _, err = r.ResolveSingle(ctx, nd, entry)
+	assert.ErrorIs(t, err, errTransport)
+	assert.False(t, errors.As(err, &errNoLink), "unexpected ErrNoLink: %v", err)
+}
+
+type closingFactory struct {
+	fetcher.Factory
+	closed bool
+}
+
+func (f *closingFactory) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestResolverClose(t *testing.T) {
+	bsrv := dagmock.Bserv()
+
+	// stateless factories are left alone
+	require.NoError(t, resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).Close())
+
+	primary := &closingFactory{Factory: newUnixFSFetcherFactory(bsrv)}
+	secondary := &closingFactory{Factory: newUnixFSFetcherFactory(bsrv)}
+	r := resolver.NewFallbackResolver(primary, secondary)
+	require.NoError(t, r.Close())
+	assert.True(t, primary.closed)
+	assert.True(t, secondary.closed)
+}
+
+func TestResolveCaseInsensitiveSegments(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	dir := randNode()
+	photos := randNode()
+	upperMusic := randNode()
+	lowerMusic := randNode()
+	require.NoError(t, dir.AddNodeLink("photos", photos))
+	require.NoError(t, dir.AddNodeLink("music", lowerMusic))
+	require.NoError(t, dir.AddNodeLink("Music", upperMusic))
+	for _, n := range []*merkledag.ProtoNode{dir, photos, upperMusic, lowerMusic} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithCaseInsensitiveSegments())
+	cases := map[string]cid.Cid{
+		"photos": photos.Cid(),
+		"Photos": photos.Cid(),
+		"music":  lowerMusic.Cid(),
+		"Music":  upperMusic.Cid(),
+		// ambiguous: the first match in byte-wise order wins
+		"MUSIC": upperMusic.Cid(),
+	}
+	for name, expected := range cases {
+		p, err := path.FromSegments("/ipfs/", dir.Cid().String(), name)
+		require.NoError(t, err)
+
+		rCid, _, err := r.ResolveToLastNode(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, expected, rCid, "ResolveToLastNode %s", name)
+
+		_, lnk, err := r.ResolvePath(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, cidlink.Link{Cid: expected}, lnk, "ResolvePath %s", name)
+	}
+
+	// exact matching by default
+	p, err := path.FromSegments("/ipfs/", dir.Cid().String(), "Photos")
+	require.NoError(t, err)
+	_, _, err = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).ResolveToLastNode(ctx, p)
+	require.True(t, errors.As(err, new(resolver.ErrNoLink)))
+}
+
+func TestResolveLinkNameTransformer(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	dir := randNode()
+	docs := randNode()
+	readme := randNode()
+	require.NoError(t, docs.AddNodeLink("README", readme))
+	require.NoError(t, dir.AddNodeLink("DOCS", docs))
+	for _, n := range []*merkledag.ProtoNode{dir, docs, readme} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithLinkNameTransformer(strings.ToUpper))
+	p, err := path.FromSegments("/ipfs/", dir.Cid().String(), "docs", "readme")
+	require.NoError(t, err)
+
+	rCid, remainder, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, readme.Cid(), rCid)
+	assert.Empty(t, remainder)
+
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: readme.Cid()}, lnk)
+
+	// errors name the segment as requested
+	p, err = path.FromSegments("/ipfs/", dir.Cid().String(), "docs", "missing")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	var noLink resolver.ErrNoLink
+	require.ErrorAs(t, err, &noLink)
+	assert.Equal(t, "missing", noLink.Name)
+
+	// without the transformer, the lowercase request does not match
+	_, _, err = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).ResolvePath(ctx, path.FromString("/ipfs/"+dir.Cid().String()+"/docs"))
+	assert.ErrorAs(t, err, &noLink)
+}
+
+func TestResolveEscapedSegments(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, strings.NewReader(`{"a/b": {"c": "slash"}, "d\\e": "backslash"}`))
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv), resolver.WithEscapedSegments())
+
+	nd, _, err := r.ResolvePath(ctx, path.FromString(lnk.String()+`/a\/b/c`))
+	require.NoError(t, err)
+	s, err := nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "slash", s)
+
+	nd, _, err = r.ResolvePath(ctx, path.FromString(lnk.String()+`/d\\e`))
+	require.NoError(t, err)
+	s, err = nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "backslash", s)
+
+	rCid, remainder, err := r.ResolveToLastNode(ctx, path.FromString(lnk.String()+`/a\/b/c`))
+	require.NoError(t, err)
+	assert.Equal(t, lnk, rCid)
+	assert.Equal(t, []string{"a/b", "c"}, remainder)
+
+	// an encoded slash in a gateway path is kept escaped within its segment
+	decoded, err := path.ParsePathDecoded("/ipfs/" + lnk.String() + "/a%2Fb/c")
+	require.NoError(t, err)
+	nd, _, err = r.ResolvePath(ctx, decoded)
+	require.NoError(t, err)
+	s, err = nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "slash", s)
+
+	// as is an encoded backslash, without an encoded slash in the path
+	decoded, err = path.ParsePathDecoded("/ipfs/" + lnk.String() + "/d%5Ce")
+	require.NoError(t, err)
+	nd, _, err = r.ResolvePath(ctx, decoded)
+	require.NoError(t, err)
+	s, err = nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "backslash", s)
+
+	// without the option, the slash delimits segments
+	_, _, err = resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv)).ResolvePath(ctx, path.FromString(lnk.String()+`/a\/b/c`))
+	require.Error(t, err)
+}
+
+func TestResolvePathSteps(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+
+	steps, err := r.ResolvePathSteps(ctx, p)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	assert.Equal(t, "", steps[0].Name)
+	assert.Equal(t, a.Cid(), steps[0].Cid)
+	assert.Equal(t, "child", steps[1].Name)
+	assert.Equal(t, b.Cid(), steps[1].Cid)
+	assert.Equal(t, "grandchild", steps[2].Name)
+	assert.Equal(t, c.Cid(), steps[2].Cid)
+
+	nodes, err := r.ResolvePathComponents(ctx, p)
+	require.NoError(t, err)
+	for i, step := range steps {
+		assert.True(t, ipld.DeepEqual(nodes[i], step.Node))
+	}
+}
+
+func TestResolvePathStepsBlockSize(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	leaf := randNode()
+	require.NoError(t, bsrv.AddBlock(ctx, leaf))
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, strings.NewReader(`{"foo": {"leaf": {"/": "`+leaf.Cid().String()+`"}}}`))
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	steps, err := r.ResolvePathSteps(ctx, path.FromString(lnk.String()+"/foo/leaf"))
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	assert.Equal(t, len(blk.RawData()), steps[0].BlockSize)
+	// foo is within the root block
+	assert.Equal(t, 0, steps[1].BlockSize)
+	assert.Equal(t, len(leaf.RawData()), steps[2].BlockSize)
+}
+
+func TestResolveCidChain(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+
+	chain, remainder, err := r.ResolveCidChain(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{a.Cid(), b.Cid(), c.Cid()}, chain)
+	assert.Empty(t, remainder)
+	// the grandchild itself is not fetched
+	assert.Equal(t, 2, bstore.Gets())
+
+	lastCid, _, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, lastCid, chain[len(chain)-1])
+
+	chain, remainder, err = r.ResolveCidChain(ctx, path.FromCid(a.Cid()))
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{a.Cid()}, chain)
+	assert.Empty(t, remainder)
+
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "missing")
+	require.NoError(t, err)
+	_, _, err = r.ResolveCidChain(ctx, p)
+	var noLink resolver.ErrNoLink
+	require.ErrorAs(t, err, &noLink)
+	assert.Equal(t, b.Cid(), noLink.Node)
+}
+
+func TestResolveCidChain_Remainder(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	leaf := randNode()
+	require.NoError(t, bsrv.AddBlock(ctx, leaf))
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, strings.NewReader(`{"foo": {"leaf": {"/": "`+leaf.Cid().String()+`"}, "bar": "baz"}}`))
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	chain, remainder, err := r.ResolveCidChain(ctx, path.FromString(lnk.String()+"/foo/bar"))
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{lnk}, chain)
+	assert.Equal(t, []string{"foo", "bar"}, remainder)
+
+	chain, remainder, err = r.ResolveCidChain(ctx, path.FromString(lnk.String()+"/foo/leaf"))
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{lnk, leaf.Cid()}, chain)
+	assert.Empty(t, remainder)
+}
+
+func TestResolveRawLeavesAsFiles(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	raw := merkledag.NewRawNode([]byte("hello"))
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("raw", raw))
+	require.NoError(t, dir.AddNodeLink("file", file))
+	for _, n := range []format.Node{raw, file, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	rawPath, err := path.FromSegments("/ipfs/", dir.Cid().String(), "raw")
+	require.NoError(t, err)
+	filePath, err := path.FromSegments("/ipfs/", dir.Cid().String(), "file")
+	require.NoError(t, err)
+
+	// by default, raw leaves are bytes nodes
+	nd, lnk, err := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).ResolvePath(ctx, rawPath)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: raw.Cid()}, lnk)
+	assert.Equal(t, ipld.Kind_Bytes, nd.Kind())
+
+	fileData := func(nd ipld.Node) []byte {
+		pbnd, ok := nd.(interface{ FieldData() dagpb.MaybeBytes })
+		require.True(t, ok, "%T does not hold UnixFS data", nd)
+		fsdata, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, data.Data_File, fsdata.FieldDataType().Int())
+		return fsdata.FieldData().Must().Bytes()
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithRawLeavesAsFiles())
+	nd, lnk, err = r.ResolvePath(ctx, rawPath)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: raw.Cid()}, lnk)
+	assert.Equal(t, []byte("hello"), fileData(nd))
+
+	nd, lnk, err = r.ResolvePath(ctx, filePath)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+	assert.Equal(t, []byte("hello"), fileData(nd))
+}
+
+// slowBlockstore delays getting one block, unless the context is done first.
+type slowBlockstore struct {
+	blockstore.Blockstore
+	slow  cid.Cid
+	delay time.Duration
+}
+
+func (bs *slowBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if c.Equals(bs.slow) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(bs.delay):
+		}
+	}
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func TestResolvePerHopTimeout(t *testing.T) {
+	ctx := context.Background()
+	bstore := &slowBlockstore{Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), delay: 200 * time.Millisecond}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	bstore.slow = b.Cid()
+
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithPerHopTimeout(20*time.Millisecond))
+	_, _, err = r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, resolver.ErrHopTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the overall context still applies
+	shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	r = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithPerHopTimeout(time.Second))
+	_, _, err = r.ResolvePath(shortCtx, p)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, errors.Is(err, resolver.ErrHopTimeout))
+
+	// every hop is within the timeout
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: c.Cid()}, lnk)
+}
+
+// ctxBlockstore fails to get blocks with a done context, as a blockstore
+// fetching them from the network would.
+type ctxBlockstore struct {
+	blockstore.Blockstore
+}
+
+func (bs *ctxBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func TestResolvePerHopTimeoutHAMTShard(t *testing.T) {
+	ctx := context.Background()
+	bstore := &ctxBlockstore{Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bsrv)
+
+	leaf := unixfsNode(t, data.Data_File, []byte("hello"))
+	require.NoError(t, dserv.Add(ctx, leaf))
+	shard, err := hamt.NewShard(dserv, 256)
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), leaf))
+	}
+	dir, err := shard.Node()
+	require.NoError(t, err)
+	root := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, root.AddNodeLink("dir", dir))
+	require.NoError(t, dserv.Add(ctx, root))
+
+	// the shards below the root of the directory are loaded after it is
+	// fetched, within the same hop
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithPerHopTimeout(time.Second))
+	p, err := path.FromSegments("/ipfs/", root.Cid().String(), "dir", "entry-1234")
+	require.NoError(t, err)
+
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: leaf.Cid()}, lnk)
+
+	rCid, rest, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, leaf.Cid(), rCid)
+}
+
+func TestResolveWithNodeReifier(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	// exposes the links of dag-pb nodes under upper-cased names
+	upper := func(_ ipld.LinkContext, nd ipld.Node, _ *ipld.LinkSystem) (ipld.Node, error) {
+		pbnd, ok := nd.(dagpb.PBNode)
+		if !ok {
+			return nd, nil
+		}
+		nb := basicnode.Prototype.Map.NewBuilder()
+		ma, err := nb.BeginMap(-1)
+		if err != nil {
+			return nil, err
+		}
+		for it := pbnd.FieldLinks().Iterator(); !it.Done(); {
+			_, lnk := it.Next()
+			if err := ma.AssembleKey().AssignString(strings.ToUpper(lnk.FieldName().Must().String())); err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleValue().AssignLink(lnk.FieldHash().Link()); err != nil {
+				return nil, err
+			}
+		}
+		if err := ma.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithNodeReifier(upper))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "CHILD")
+	require.NoError(t, err)
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: b.Cid()}, lnk)
+
+	// the factory's own reifier is not used
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	require.Error(t, err)
+
+	nd, lnk, err := r.ResolvePath(ctx, path.FromCid(a.Cid()))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: a.Cid()}, lnk)
+	nodes, err := r.ResolveLinks(ctx, nd, []string{"CHILD"})
+	require.NoError(t, err)
+	assert.Len(t, nodes, 2)
+}
+
+func TestResolveWithoutReification(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("file", file))
+	for _, n := range []format.Node{file, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	nd, _, err := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).ResolvePath(ctx, path.FromCid(dir.Cid()))
+	require.NoError(t, err)
+	_, isPBNode := nd.(dagpb.PBNode)
+	assert.False(t, isPBNode, "expected a reified directory, got %T", nd)
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithoutReification())
+	nd, _, err = r.ResolvePath(ctx, path.FromCid(dir.Cid()))
+	require.NoError(t, err)
+	_, isPBNode = nd.(dagpb.PBNode)
+	assert.True(t, isPBNode, "expected a PBNode, got %T", nd)
+
+	// names are no longer segments, the dag-pb fields are
+	p, err := path.FromSegments("/ipfs/", dir.Cid().String(), "file")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	assert.ErrorAs(t, err, &resolver.ErrNoLink{})
+	p, err = path.FromSegments("/ipfs/", dir.Cid().String(), "Links", "0", "Hash")
+	require.NoError(t, err)
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+}
+
+func TestResolveListIndex(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, strings.NewReader(`[["a", "b"], ["c"]]`))
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	lnk, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), lnk)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	r := resolver.NewBasicResolver(bsfetcher.NewFetcherConfig(bsrv))
+
+	nd, _, err := r.ResolvePath(ctx, path.FromString(lnk.String()+"/0/1"))
+	require.NoError(t, err)
+	s, err := nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "b", s)
+
+	rCid, remainder, err := r.ResolveToLastNode(ctx, path.FromString(lnk.String()+"/1/0"))
+	require.NoError(t, err)
+	assert.Equal(t, lnk, rCid)
+	assert.Equal(t, []string{"1", "0"}, remainder)
+
+	for _, p := range []string{"/0/2", "/2", "/1/-1"} {
+		_, _, err = r.ResolvePath(ctx, path.FromString(lnk.String()+p))
+		require.ErrorIs(t, err, resolver.ErrIndexOutOfRange, "ResolvePath(%s)", p)
+		_, _, err = r.ResolveToLastNode(ctx, path.FromString(lnk.String()+p))
+		require.ErrorIs(t, err, resolver.ErrIndexOutOfRange, "ResolveToLastNode(%s)", p)
+	}
+
+	_, _, err = r.ResolvePath(ctx, path.FromString(lnk.String()+"/0/foo"))
+	require.True(t, errors.As(err, new(resolver.ErrNoLink)), "got %v", err)
+}
+
+func TestResolvePathStepsRawName(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	dir := randNode()
+	file := randNode()
+	require.NoError(t, dir.AddNodeLink("hello world", file))
+	for _, n := range []*merkledag.ProtoNode{dir, file} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithPercentDecodedSegments())
+	steps, err := r.ResolvePathSteps(ctx, path.FromString("/ipfs/"+dir.Cid().String()+"/hello%20world"))
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, "hello world", steps[1].Name)
+	assert.Equal(t, "hello%20world", steps[1].RawName)
+	assert.Equal(t, file.Cid(), steps[1].Cid)
+
+	_, lnk, err := r.ResolvePath(ctx, path.FromString("/ipfs/"+dir.Cid().String()+"/hello%20world"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+
+	_, _, err = r.ResolvePath(ctx, path.FromString("/ipfs/"+dir.Cid().String()+"/hello%2"))
+	require.Error(t, err)
+
+	// without decoding, both names are the same
+	steps, err = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).ResolvePathSteps(ctx, path.FromString("/ipfs/"+dir.Cid().String()+"/hello world"))
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, "hello world", steps[1].Name)
+	assert.Equal(t, "hello world", steps[1].RawName)
+}
+
+// inFlightBlockstore records the maximum number of concurrent gets.
+type inFlightBlockstore struct {
+	blockstore.Blockstore
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (bs *inFlightBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	bs.mu.Lock()
+	bs.inFlight++
+	if bs.inFlight > bs.max {
+		bs.max = bs.inFlight
+	}
+	bs.mu.Unlock()
+	defer func() {
+		bs.mu.Lock()
+		bs.inFlight--
+		bs.mu.Unlock()
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func TestResolveBatch(t *testing.T) {
+	ctx := context.Background()
+	bstore := &inFlightBlockstore{Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+
+	var paths []path.Path
+	var expected []cid.Cid
+	for i := 0; i < 10; i++ {
+		a := randNode()
+		b := randNode()
+		require.NoError(t, a.AddNodeLink("child", b))
+		require.NoError(t, bsrv.AddBlock(ctx, a))
+		require.NoError(t, bsrv.AddBlock(ctx, b))
+
+		name := "child"
+		if i%3 == 0 {
+			name = "missing"
+		}
+		p, err := path.FromSegments("/ipfs/", a.Cid().String(), name)
+		require.NoError(t, err)
+		paths = append(paths, p)
+		expected = append(expected, b.Cid())
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	results := r.ResolveBatch(ctx, paths, 3)
+	require.Len(t, results, len(paths))
+	for i, res := range results {
+		if i%3 == 0 {
+			var errNoLink resolver.ErrNoLink
+			require.True(t, errors.As(res.Err, &errNoLink), "path %d returned %v", i, res.Err)
+			assert.Equal(t, "missing", errNoLink.Name)
+			continue
+		}
+		require.NoError(t, res.Err)
+		assert.Equal(t, expected[i], res.Cid, "path %d", i)
+		assert.Empty(t, res.Remainder)
+	}
+	assert.LessOrEqual(t, bstore.max, 3)
+	assert.Greater(t, bstore.max, 1)
+}
+
+func TestResolveBatchMixedNamespaces(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	// /ipld/ paths are resolved through the dag-pb data model within the
+	// batch too
+	paths := []path.Path{
+		path.FromString("/ipfs/" + a.Cid().String() + "/child"),
+		path.FromString("/ipld/" + a.Cid().String() + "/Links/0/Hash"),
+		path.FromString("/ipfs/" + a.Cid().String() + "/child"),
+		path.FromString("/ipld/" + a.Cid().String() + "/child"),
+	}
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	for _, concurrency := range []int{1, 4} {
+		results := r.ResolveBatch(ctx, paths, concurrency)
+		require.Len(t, results, len(paths))
+		for i, res := range results[:3] {
+			require.NoError(t, res.Err, "path %d", i)
+			assert.Equal(t, b.Cid(), res.Cid, "path %d", i)
+			assert.Empty(t, res.Remainder, "path %d", i)
+		}
+		assert.True(t, errors.As(results[3].Err, new(resolver.ErrNoLink)), "got %v", results[3].Err)
+	}
+}
+
+// inFlightFetcher records the maximum number of concurrent block fetches of
+// its sessions.
+type inFlightFetcher struct {
+	fetcher.Factory
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (f *inFlightFetcher) NewSession(ctx context.Context) fetcher.Fetcher {
+	return &inFlightSession{Fetcher: f.Factory.NewSession(ctx), f: f}
+}
+
+type inFlightSession struct {
+	fetcher.Fetcher
+	f *inFlightFetcher
+}
+
+func (s *inFlightSession) BlockOfType(ctx context.Context, lnk ipld.Link, proto ipld.NodePrototype) (ipld.Node, error) {
+	s.f.mu.Lock()
+	s.f.inFlight++
+	if s.f.inFlight > s.f.max {
+		s.f.max = s.f.inFlight
+	}
+	s.f.mu.Unlock()
+	defer func() {
+		s.f.mu.Lock()
+		s.f.inFlight--
+		s.f.mu.Unlock()
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	return s.Fetcher.BlockOfType(ctx, lnk, proto)
+}
+
+func TestResolveMaxConcurrentFetches(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	var paths []path.Path
+	for i := 0; i < 10; i++ {
+		a := randNode()
+		b := randNode()
+		require.NoError(t, a.AddNodeLink("child", b))
+		require.NoError(t, bsrv.AddBlock(ctx, a))
+		require.NoError(t, bsrv.AddBlock(ctx, b))
+		p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child")
+		require.NoError(t, err)
+		paths = append(paths, p)
+	}
+
+	for _, limit := range []int{1, 2} {
+		f := &inFlightFetcher{Factory: newUnixFSFetcherFactory(bsrv)}
+		r := resolver.NewBasicResolver(f, resolver.WithMaxConcurrentFetches(limit))
+		for _, res := range r.ResolveBatch(ctx, paths, 5) {
+			require.NoError(t, res.Err)
+		}
+		assert.LessOrEqual(t, f.max, limit)
+		assert.Greater(t, f.max, 0)
+	}
+
+	// and for the shards of sharded directories, loaded as entries are
+	// looked up
+	bstore := &inFlightBlockstore{Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))}
+	shardBsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(shardBsrv)
+	leaf := unixfsNode(t, data.Data_File, []byte("hello"))
+	require.NoError(t, dserv.Add(ctx, leaf))
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), leaf))
+	}
+	shardRoot, err := shard.Node()
+	require.NoError(t, err)
+	require.NoError(t, dserv.Add(ctx, shardRoot))
+	var shardPaths []path.Path
+	for i := 0; i < 10; i++ {
+		p, err := path.FromSegments("/ipfs/", shardRoot.Cid().String(), fmt.Sprintf("entry-%d", i*37))
+		require.NoError(t, err)
+		shardPaths = append(shardPaths, p)
+	}
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(shardBsrv), resolver.WithMaxConcurrentFetches(1))
+	for _, res := range r.ResolveBatch(ctx, shardPaths, 5) {
+		require.NoError(t, res.Err)
+		assert.Equal(t, leaf.Cid(), res.Cid)
+	}
+	assert.Equal(t, 1, bstore.max)
+
+	// the limit also holds for blocks fetched with per-hop timeouts, which
+	// are fetched with sessions of their own
+	f := &inFlightFetcher{Factory: newUnixFSFetcherFactory(bsrv)}
+	r = resolver.NewBasicResolver(f, resolver.WithMaxConcurrentFetches(2), resolver.WithPerHopTimeout(time.Second))
+	for _, res := range r.ResolveBatch(ctx, paths, 5) {
+		require.NoError(t, res.Err)
+	}
+	assert.LessOrEqual(t, f.max, 2)
+
+	// without a limit, the batch fetches more blocks at once
+	f = &inFlightFetcher{Factory: newUnixFSFetcherFactory(bsrv)}
+	r = resolver.NewBasicResolver(f)
+	for _, res := range r.ResolveBatch(ctx, paths, 5) {
+		require.NoError(t, res.Err)
+	}
+	assert.Greater(t, f.max, 2)
+}
+
+func TestResolveRoot(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+	root := addChain(ctx, t, bsrv, 2)
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", root.Cid().String(), "child", "child")
+	require.NoError(t, err)
+
+	c, nd, err := r.ResolveRoot(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, root.Cid(), c)
+	_, err = nd.LookupByString("child")
+	require.NoError(t, err)
+	// the subpath is not traversed
+	assert.Equal(t, 1, bstore.Gets())
+
+	p, err = path.FromSegments("/ipns/", root.Cid().String(), "child")
+	require.NoError(t, err)
+	_, _, err = r.ResolveRoot(ctx, p)
+	require.Error(t, err)
+	assert.Equal(t, 1, bstore.Gets())
+}
+
+func TestResolveIPNSPaths(t *testing.T) {
+	ctx := context.Background()
+	bsrv, bstore := newCountingBserv()
+	root := addChain(ctx, t, bsrv, 1)
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	// names are mutable, so they are never resolved as content, even when
+	// they are CIDs
+	_, _, err := r.ResolveToLastNode(ctx, path.FromString("/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"))
+	assert.ErrorIs(t, err, path.ErrBadPath)
+
+	p, err := path.FromSegments("/ipns/", root.Cid().String(), "child")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	assert.ErrorIs(t, err, path.ErrBadPath)
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	assert.ErrorIs(t, err, path.ErrBadPath)
+	assert.Zero(t, bstore.Gets())
+}
+
+func TestResolveIPLDNamespace(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	// /ipfs/ paths are resolved through the reified nodes
+	_, lnk, err := r.ResolvePath(ctx, path.FromString("/ipfs/"+a.Cid().String()+"/child"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: b.Cid()}, lnk)
+	_, _, err = r.ResolvePath(ctx, path.FromString("/ipfs/"+a.Cid().String()+"/Links/0/Hash"))
+	require.Error(t, err)
+
+	// /ipld/ paths are resolved through the dag-pb data model
+	nd, lnk, err := r.ResolvePath(ctx, path.FromString("/ipld/"+a.Cid().String()+"/Links/0/Hash"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: b.Cid()}, lnk)
+	_, err = nd.LookupByString("Links")
+	require.NoError(t, err)
+
+	nd, _, err = r.ResolvePath(ctx, path.FromString("/ipld/"+a.Cid().String()+"/Links/0/Name"))
+	require.NoError(t, err)
+	name, err := nd.AsString()
+	require.NoError(t, err)
+	assert.Equal(t, "child", name)
+
+	_, _, err = r.ResolvePath(ctx, path.FromString("/ipld/"+a.Cid().String()+"/child"))
+	require.True(t, errors.As(err, new(resolver.ErrNoLink)), "got %v", err)
+
+	rCid, remainder, err := r.ResolveToLastNode(ctx, path.FromString("/ipld/"+a.Cid().String()+"/Links/0/Hash"))
+	require.NoError(t, err)
+	assert.Equal(t, b.Cid(), rCid)
+	assert.Empty(t, remainder)
+}
+
+func TestResolveToLastNodeKind(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	raw := merkledag.NewRawNode([]byte("hello"))
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	link := unixfsNode(t, data.Data_Symlink, []byte("file"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("raw", raw))
+	require.NoError(t, dir.AddNodeLink("file", file))
+	require.NoError(t, dir.AddNodeLink("link", link))
+	require.NoError(t, dir.AddNodeLink("sub", sub))
+	for _, n := range []format.Node{raw, file, link, sub, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	for name, tc := range map[string]struct {
+		c    cid.Cid
+		kind resolver.LeafKind
+	}{
+		"raw":  {raw.Cid(), resolver.LeafRaw},
+		"file": {file.Cid(), resolver.LeafFile},
+		"link": {link.Cid(), resolver.LeafSymlink},
+		"sub":  {sub.Cid(), resolver.LeafDirectory},
+	} {
+		p, err := path.FromSegments("/ipfs/", dir.Cid().String(), name)
+		require.NoError(t, err)
+		c, kind, err := r.ResolveToLastNodeKind(ctx, p)
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.c, c, name)
+		assert.Equal(t, tc.kind, kind, name)
+	}
+
+	c, kind, err := r.ResolveToLastNodeKind(ctx, path.FromCid(dir.Cid()))
+	require.NoError(t, err)
+	assert.Equal(t, dir.Cid(), c)
+	assert.Equal(t, resolver.LeafDirectory, kind)
+	assert.Equal(t, "directory", kind.String())
+
+	p, err := path.FromSegments("/ipfs/", dir.Cid().String(), "missing")
+	require.NoError(t, err)
+	_, _, err = r.ResolveToLastNodeKind(ctx, p)
+	assert.ErrorAs(t, err, &resolver.ErrNoLink{})
+}
+
+// staticFetcher serves nodes from a map without verifying them against their
+// cids, as needed to build DAGs that cannot exist otherwise.
+type staticFetcher struct {
+	fetcher.Fetcher
+	nodes map[cid.Cid]ipld.Node
+}
+
+func (f *staticFetcher) NewSession(ctx context.Context) fetcher.Fetcher {
+	return f
+}
+
+func (f *staticFetcher) PrototypeFromLink(ipld.Link) (ipld.NodePrototype, error) {
+	return basicnode.Prototype.Any, nil
+}
+
+func (f *staticFetcher) BlockOfType(ctx context.Context, lnk ipld.Link, _ ipld.NodePrototype) (ipld.Node, error) {
+	nd, ok := f.nodes[lnk.(cidlink.Link).Cid]
+	if !ok {
+		return nil, format.ErrNotFound
+	}
+	return nd, nil
+}
+
+func TestResolveCyclicLink(t *testing.T) {
+	ctx := context.Background()
+
+	mh, err := multihash.Sum([]byte("loop"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	self := cid.NewCidV1(cid.DagCBOR, mh)
+	nb := basicnode.Prototype.Any.NewBuilder()
+	ma, err := nb.BeginMap(1)
+	require.NoError(t, err)
+	require.NoError(t, ma.AssembleKey().AssignString("next"))
+	require.NoError(t, ma.AssembleValue().AssignLink(cidlink.Link{Cid: self}))
+	require.NoError(t, ma.Finish())
+
+	r := resolver.NewBasicResolver(&staticFetcher{nodes: map[cid.Cid]ipld.Node{self: nb.Build()}})
+	p, err := path.FromSegments("/ipfs/", self.String(), "next", "next", "next")
+	require.NoError(t, err)
+
+	_, _, err = r.ResolvePath(ctx, p)
+	assert.ErrorIs(t, err, resolver.ErrCyclicLink)
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	assert.ErrorIs(t, err, resolver.ErrCyclicLink)
+
+	// the cycle is only followed when the path asks for it
+	nd, _, err := r.ResolvePath(ctx, path.FromCid(self))
+	require.NoError(t, err)
+	assert.Equal(t, ipld.Kind_Map, nd.Kind())
+}
+
+func TestResolveEntries(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	dserv := merkledag.NewDAGService(bsrv)
+
+	a := unixfsNode(t, data.Data_File, []byte("a"))
+	b := unixfsNode(t, data.Data_File, []byte("b"))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("a", a))
+	require.NoError(t, dir.AddNodeLink("b", b))
+	for _, n := range []format.Node{a, b, dir} {
+		require.NoError(t, dserv.Add(ctx, n))
+	}
+
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	expected := map[string]cid.Cid{}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("entry-%d", i)
+		require.NoError(t, shard.Set(ctx, name, a))
+		expected[name] = a.Cid()
+	}
+	shardRoot, err := shard.Node()
+	require.NoError(t, err)
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	aSize, err := a.Size()
+	require.NoError(t, err)
+	bSize, err := b.Size()
+	require.NoError(t, err)
+	flat := []resolver.Entry{{Name: "a", Cid: a.Cid(), Size: int64(aSize)}, {Name: "b", Cid: b.Cid(), Size: int64(bSize)}}
+	entries, err := r.ResolveEntries(ctx, path.FromCid(dir.Cid()))
+	require.NoError(t, err)
+	assert.Equal(t, flat, entries)
+
+	// unreified basic directories are listed too
+	entries, err = r.ResolveEntries(ctx, path.FromString("/ipld/"+dir.Cid().String()))
+	require.NoError(t, err)
+	assert.Equal(t, flat, entries)
+
+	entries, err = r.ResolveEntries(ctx, path.FromCid(shardRoot.Cid()))
+	require.NoError(t, err)
+	got := map[string]cid.Cid{}
+	for _, e := range entries {
+		got[e.Name] = e.Cid
+		assert.Equal(t, int64(aSize), e.Size, e.Name)
+	}
+	assert.Len(t, entries, len(expected))
+	assert.Equal(t, expected, got)
+
+	filePath, err := path.FromSegments("/ipfs/", dir.Cid().String(), "a")
+	require.NoError(t, err)
+	_, err = r.ResolveEntries(ctx, filePath)
+	assert.ErrorIs(t, err, resolver.ErrNotADirectory)
+}
+
+func TestResolveMetadataNode(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	child := unixfsNode(t, data.Data_File, []byte("child"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, sub.AddNodeLink("child", child))
+	fileMeta := unixfsNode(t, data.Data_Metadata, []byte("text/plain"))
+	require.NoError(t, fileMeta.AddNodeLink("", file))
+	subMeta := unixfsNode(t, data.Data_Metadata, nil)
+	require.NoError(t, subMeta.AddNodeLink("", sub))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("file", fileMeta))
+	require.NoError(t, dir.AddNodeLink("sub", subMeta))
+	for _, n := range []format.Node{file, child, sub, fileMeta, subMeta, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	filePath, err := path.FromSegments("/ipfs/", dir.Cid().String(), "file")
+	require.NoError(t, err)
+	nd, lnk, err := r.ResolvePath(ctx, filePath)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+	pbnd, ok := nd.(interface{ FieldData() dagpb.MaybeBytes })
+	require.True(t, ok)
+	assert.Equal(t, file.Data(), pbnd.FieldData().Must().Bytes())
+
+	// paths cross Metadata nodes to the content they wrap
+	childPath, err := path.FromSegments("/ipfs/", dir.Cid().String(), "sub", "child")
+	require.NoError(t, err)
+	rCid, remainder, err := r.ResolveToLastNode(ctx, childPath)
+	require.NoError(t, err)
+	assert.Equal(t, child.Cid(), rCid)
+	assert.Empty(t, remainder)
+
+	steps, err := r.ResolvePathSteps(ctx, childPath)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	assert.Equal(t, sub.Cid(), steps[1].Cid)
+
+	// the Metadata nodes crossed are part of the chain of blocks
+	chain, remainder, err := r.ResolveCidChain(ctx, childPath)
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{dir.Cid(), subMeta.Cid(), sub.Cid(), child.Cid()}, chain)
+	assert.Empty(t, remainder)
+
+	// as are paths rooted at a Metadata node
+	_, lnk, err = r.ResolvePath(ctx, path.FromCid(fileMeta.Cid()))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+
+	// /ipld/ paths resolve the Metadata node itself
+	_, lnk, err = r.ResolvePath(ctx, path.FromString("/ipld/"+dir.Cid().String()+"/Links/0/Hash"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: fileMeta.Cid()}, lnk)
+	_, lnk, err = r.ResolvePath(ctx, path.FromString("/ipld/"+fileMeta.Cid().String()))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: fileMeta.Cid()}, lnk)
+}
+
+func TestResolveTrailingSlashDirectories(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	sub := unixfsNode(t, data.Data_Directory, nil)
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("file", file))
+	require.NoError(t, dir.AddNodeLink("sub", sub))
+	for _, n := range []format.Node{file, sub, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithTrailingSlashDirectories())
+	root := "/ipfs/" + dir.Cid().String()
+
+	_, lnk, err := r.ResolvePath(ctx, path.FromString(root+"/sub/"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: sub.Cid()}, lnk)
+	_, lnk, err = r.ResolvePath(ctx, path.FromString(root+"/"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: dir.Cid()}, lnk)
+
+	_, _, err = r.ResolvePath(ctx, path.FromString(root+"/file/"))
+	assert.ErrorIs(t, err, resolver.ErrNotADirectory)
+	_, _, err = r.ResolveToLastNodeKind(ctx, path.FromString(root+"/file/"))
+	assert.ErrorIs(t, err, resolver.ErrNotADirectory)
+
+	_, lnk, err = r.ResolvePath(ctx, path.FromString(root+"/file"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+
+	// without the option, the trailing slash means nothing
+	_, lnk, err = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv)).ResolvePath(ctx, path.FromString(root+"/file/"))
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: file.Cid()}, lnk)
+}
+
+// failingBlockstore fails to get one block with a transport error, the first
+// failures times it is asked for if failures is positive, and always
+// otherwise.
+type failingBlockstore struct {
+	blockstore.Blockstore
+	failing  cid.Cid
+	failures int32
+	attempts int32
+}
+
+var errTransport = errors.New("connection reset by peer")
+
+func (bs *failingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if c.Equals(bs.failing) {
+		n := atomic.AddInt32(&bs.attempts, 1)
+		if bs.failures <= 0 || n <= bs.failures {
+			return nil, errTransport
+		}
+	}
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func TestResolveFetchFailed(t *testing.T) {
+	ctx := context.Background()
+
+	a := randNode()
+	b := randNode()
+	missing := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	require.NoError(t, a.AddNodeLink("missing", missing))
+
+	bstore := &failingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+		failing:    b.Cid(),
+	}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	for _, n := range []*merkledag.ProtoNode{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+
+	// transport errors are retryable
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	var fetchErr resolver.ErrFetchFailed
+	require.ErrorAs(t, err, &fetchErr)
+	assert.Equal(t, b.Cid(), fetchErr.Cid)
+	assert.ErrorIs(t, err, errTransport)
+	assert.False(t, errors.As(err, &resolver.ErrNoLink{}))
+
+	// missing blocks and links are not
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "missing")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &fetchErr), "unexpected ErrFetchFailed: %v", err)
+
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "nonexistent")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	assert.ErrorAs(t, err, &resolver.ErrNoLink{})
+	assert.False(t, errors.As(err, &fetchErr))
+}
+
+// newFailingShard returns a blockservice holding a sharded directory of which
+// a child shard always fails to be fetched with errTransport, along with the
+// cid of the directory and the name of an entry within the failing shard.
+func newFailingShard(t *testing.T) (blockservice.BlockService, cid.Cid, string) {
+	ctx := context.Background()
+	bstore := &failingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+	}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bsrv)
+
+	leaf := unixfsNode(t, data.Data_File, []byte("hello"))
+	require.NoError(t, dserv.Add(ctx, leaf))
+	shard, err := hamt.NewShard(dserv, 256)
+	require.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), leaf))
+	}
+	root, err := shard.Node()
+	require.NoError(t, err)
+
+	// fail the first child shard of the root holding an entry: links to
+	// child shards are named with their slot only
+	for _, l := range root.Links() {
+		if len(l.Name) != 2 {
+			continue
+		}
+		child, err := dserv.Get(ctx, l.Cid)
+		require.NoError(t, err)
+		for _, cl := range child.Links() {
+			if len(cl.Name) > 2 {
+				bstore.failing = l.Cid
+				return bsrv, root.Cid(), cl.Name[2:]
+			}
+		}
+	}
+	t.Fatal("no child shard holding an entry")
+	return nil, cid.Undef, ""
+}
+
+func TestResolveFetchFailedWithinShard(t *testing.T) {
+	ctx := context.Background()
+	bsrv, root, entry := newFailingShard(t)
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", root.String(), entry)
+	require.NoError(t, err)
+
+	_, _, err = r.ResolvePath(ctx, p)
+	assert.ErrorAs(t, err, &resolver.ErrFetchFailed{})
+	assert.ErrorIs(t, err, errTransport)
+	assert.False(t, errors.As(err, &resolver.ErrNoLink{}), "unexpected ErrNoLink: %v", err)
+
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	assert.ErrorAs(t, err, &resolver.ErrFetchFailed{})
+	assert.ErrorIs(t, err, errTransport)
+	assert.False(t, errors.As(err, &resolver.ErrNoLink{}), "unexpected ErrNoLink: %v", err)
+}
+
+func TestResolveWithRetry(t *testing.T) {
+	ctx := context.Background()
+
+	a := randNode()
+	b := randNode()
+	missing := randNode()
+	require.NoError(t, a.AddNodeLink("child", b))
+	require.NoError(t, a.AddNodeLink("missing", missing))
+	newResolver := func(failures int32, opts ...resolver.Option) (*resolver.Resolver, *failingBlockstore) {
+		bstore := &failingBlockstore{
+			Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+			failing:    b.Cid(),
+			failures:   failures,
+		}
+		bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+		for _, n := range []*merkledag.ProtoNode{a, b} {
+			require.NoError(t, bsrv.AddBlock(ctx, n))
+		}
+		return resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), opts...), bstore
+	}
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child")
+	require.NoError(t, err)
+
+	// a fetch failing twice succeeds on the second retry
+	r, bstore := newResolver(2, resolver.WithRetry(2, time.Millisecond))
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, b.Cid(), lnk.(cidlink.Link).Cid)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&bstore.attempts))
+
+	// but not without retrying
+	r, _ = newResolver(2)
+	_, _, err = r.ResolvePath(ctx, p)
+	assert.ErrorIs(t, err, errTransport)
+
+	// a fetch always failing fails once the retries are exhausted
+	r, bstore = newResolver(0, resolver.WithRetry(3, time.Millisecond))
+	_, _, err = r.ResolvePath(ctx, p)
+	var fetchErr resolver.ErrFetchFailed
+	require.True(t, errors.As(err, &fetchErr), "expected ErrFetchFailed, got %v", err)
+	assert.ErrorIs(t, err, errTransport)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&bstore.attempts))
+
+	// retries stop with the context
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	r, _ = newResolver(0, resolver.WithRetry(3, time.Hour))
+	_, _, err = r.ResolvePath(cctx, p)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// missing blocks are not retried
+	r, _ = newResolver(0, resolver.WithRetry(3, time.Hour))
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "missing")
+	require.NoError(t, err)
+	_, _, err = r.ResolvePath(ctx, p)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &fetchErr), "unexpected ErrFetchFailed: %v", err)
+}
+
+func TestResolveFileBytes(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	small := unixfsNode(t, data.Data_File, []byte("hello"))
+	hello := merkledag.NewRawNode([]byte("hello "))
+	world := merkledag.NewRawNode([]byte("world"))
+	large := unixfsNode(t, data.Data_File, nil)
+	require.NoError(t, large.AddNodeLink("", hello))
+	require.NoError(t, large.AddNodeLink("", world))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("small", small))
+	require.NoError(t, dir.AddNodeLink("large", large))
+	require.NoError(t, dir.AddNodeLink("raw", world))
+	for _, n := range []format.Node{small, hello, world, large, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	filePath := func(name string) path.Path {
+		p, err := path.FromSegments("/ipfs/", dir.Cid().String(), name)
+		require.NoError(t, err)
+		return p
+	}
+
+	b, err := r.ResolveFileBytes(ctx, filePath("small"), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+	b, err = r.ResolveFileBytes(ctx, filePath("large"), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), b)
+	b, err = r.ResolveFileBytes(ctx, filePath("raw"), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("world"), b)
+
+	// the cap is inclusive
+	b, err = r.ResolveFileBytes(ctx, filePath("large"), 11)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), b)
+	_, err = r.ResolveFileBytes(ctx, filePath("large"), 10)
+	assert.ErrorIs(t, err, resolver.ErrFileTooLarge)
+
+	_, err = r.ResolveFileBytes(ctx, path.FromCid(dir.Cid()), 1024)
+	assert.ErrorIs(t, err, resolver.ErrNotAFile)
+}
+
+func TestResolveFileReader(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	hello := merkledag.NewRawNode([]byte("hello "))
+	world := merkledag.NewRawNode([]byte("world"))
+	// a file recording the sizes of its blocks, with data of its own
+	fsdata, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_File)
+		builder.Data(b, []byte(">> "))
+		builder.FileSize(b, 14)
+		builder.BlockSizes(b, []uint64{6, 5})
+	})
+	require.NoError(t, err)
+	sized := merkledag.NodeWithData(data.EncodeUnixFSData(fsdata))
+	require.NoError(t, sized.AddNodeLink("", hello))
+	require.NoError(t, sized.AddNodeLink("", world))
+	// and another one nested under a file which records no sizes at all
+	unsized := unixfsNode(t, data.Data_File, nil)
+	require.NoError(t, unsized.AddNodeLink("", sized))
+	require.NoError(t, unsized.AddNodeLink("", world))
+	link := unixfsNode(t, data.Data_Symlink, []byte("sized"))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	require.NoError(t, dir.AddNodeLink("sized", sized))
+	require.NoError(t, dir.AddNodeLink("unsized", unsized))
+	require.NoError(t, dir.AddNodeLink("link", link))
+	for _, n := range []format.Node{hello, world, sized, unsized, link, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	filePath := func(name string) path.Path {
+		p, err := path.FromSegments("/ipfs/", dir.Cid().String(), name)
+		require.NoError(t, err)
+		return p
+	}
+
+	for name, want := range map[string]string{
+		"sized":   ">> hello world",
+		"unsized": ">> hello worldworld",
+	} {
+		rd, size, err := r.ResolveFileReader(ctx, filePath(name))
+		require.NoError(t, err, name)
+		assert.Equal(t, int64(len(want)), size, name)
+		b, err := io.ReadAll(rd)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, string(b), name)
+
+		// seek to an offset within a block
+		off, err := rd.Seek(5, io.SeekStart)
+		require.NoError(t, err, name)
+		assert.Equal(t, int64(5), off, name)
+		b, err = io.ReadAll(rd)
+		require.NoError(t, err, name)
+		assert.Equal(t, want[5:], string(b), name)
+
+		// read a range spanning blocks
+		_, err = rd.Seek(-12, io.SeekEnd)
+		require.NoError(t, err, name)
+		b = make([]byte, 7)
+		_, err = io.ReadFull(rd, b)
+		require.NoError(t, err, name)
+		assert.Equal(t, want[len(want)-12:len(want)-5], string(b), name)
+		off, err = rd.Seek(0, io.SeekCurrent)
+		require.NoError(t, err, name)
+		assert.Equal(t, int64(len(want)-5), off, name)
+	}
+
+	_, _, err = r.ResolveFileReader(ctx, path.FromCid(dir.Cid()))
+	assert.ErrorIs(t, err, resolver.ErrNotAFile)
+	_, _, err = r.ResolveFileReader(ctx, filePath("link"))
+	assert.ErrorIs(t, err, resolver.ErrNotAFile)
+}
+
+func TestGlob(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	for _, name := range []string{"a.txt", "b.txt", "c.md", "txt"} {
+		require.NoError(t, dir.AddNodeLink(name, file))
+	}
+	for _, n := range []format.Node{file, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	base := path.FromCid(dir.Cid())
+	child := func(name string) path.Path {
+		return path.FromString(base.String() + "/" + name)
+	}
+
+	matches, err := r.Glob(ctx, base, "*.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []path.Path{child("a.txt"), child("b.txt")}, matches)
+
+	matches, err = r.Glob(ctx, path.FromString(base.String()+"/"), "c.md")
+	require.NoError(t, err)
+	assert.Equal(t, []path.Path{child("c.md")}, matches)
+
+	matches, err = r.Glob(ctx, base, "*.go")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	_, err = r.Glob(ctx, base, "[")
+	assert.Error(t, err)
+	_, err = r.Glob(ctx, base, "*/*")
+	assert.Error(t, err)
+	_, err = r.Glob(ctx, child("a.txt"), "*")
+	assert.ErrorIs(t, err, resolver.ErrNotADirectory)
+}
+
+func TestResolveEntriesSizes(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := unixfsNode(t, data.Data_File, []byte("a"))
+	b := unixfsNode(t, data.Data_File, []byte("b"))
+	for _, n := range []format.Node{a, b} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	// merkledag always records the Tsize of links, so the directory is built
+	// with a sized link and an unsized one by hand
+	dirData, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_Directory)
+	})
+	require.NoError(t, err)
+	nd, err := qp.BuildMap(dagpb.Type.PBNode, -1, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(-1, func(la ipld.ListAssembler) {
+			qp.ListEntry(la, qp.Map(-1, func(ma ipld.MapAssembler) {
+				qp.MapEntry(ma, "Hash", qp.Link(cidlink.Link{Cid: a.Cid()}))
+				qp.MapEntry(ma, "Name", qp.String("sized"))
+				qp.MapEntry(ma, "Tsize", qp.Int(42))
+			}))
+			qp.ListEntry(la, qp.Map(-1, func(ma ipld.MapAssembler) {
+				qp.MapEntry(ma, "Hash", qp.Link(cidlink.Link{Cid: b.Cid()}))
+				qp.MapEntry(ma, "Name", qp.String("unsized"))
+			}))
+		}))
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(dirData)))
+	})
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, dagpb.Encode(nd, out))
+	c, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagProtobuf,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), c)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	expected := []resolver.Entry{
+		{Name: "sized", Cid: a.Cid(), Size: 42},
+		{Name: "unsized", Cid: b.Cid(), Size: -1},
+	}
+	for _, p := range []string{"/ipfs/", "/ipld/"} {
+		entries, err := r.ResolveEntries(ctx, path.FromString(p+c.String()))
+		require.NoError(t, err, p)
+		assert.Equal(t, expected, entries, p)
+	}
+}
+
+func TestResolveEntriesMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	dserv := merkledag.NewDAGService(bsrv)
+
+	file := unixfsNode(t, data.Data_File, []byte("hello"))
+	dir := unixfsNode(t, data.Data_Directory, nil)
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("entry-%d", i)
+		require.NoError(t, dir.AddNodeLink(name, file))
+		require.NoError(t, shard.Set(ctx, name, file))
+	}
+	for _, n := range []format.Node{file, dir} {
+		require.NoError(t, dserv.Add(ctx, n))
+	}
+	shardRoot, err := shard.Node()
+	require.NoError(t, err)
+
+	for _, c := range []cid.Cid{dir.Cid(), shardRoot.Cid()} {
+		entries, err := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithMaxEntries(100)).ResolveEntries(ctx, path.FromCid(c))
+		require.NoError(t, err)
+		assert.Len(t, entries, 100)
+
+		_, err = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithMaxEntries(99)).ResolveEntries(ctx, path.FromCid(c))
+		assert.ErrorIs(t, err, resolver.ErrTooManyEntries)
+	}
+}
+
+func TestResolveN(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err := path.FromSegments("/ipfs/", a.Cid().String(), "child", "grandchild")
+	require.NoError(t, err)
+
+	cases := []struct {
+		n         int
+		expected  *merkledag.ProtoNode
+		remainder []string
+	}{
+		{0, a, []string{"child", "grandchild"}},
+		{1, b, []string{"grandchild"}},
+		{2, c, []string{}},
+		{3, c, []string{}},
+	}
+	for _, tc := range cases {
+		rCid, nd, remainder, err := r.ResolveN(ctx, p, tc.n)
+		require.NoError(t, err, "n = %d", tc.n)
+		assert.Equal(t, tc.expected.Cid(), rCid, "n = %d", tc.n)
+		assert.Equal(t, tc.remainder, remainder, "n = %d", tc.n)
+		pbnd, ok := nd.(interface{ FieldData() dagpb.MaybeBytes })
+		require.True(t, ok, "n = %d: %T", tc.n, nd)
+		assert.Equal(t, tc.expected.Data(), pbnd.FieldData().Must().Bytes(), "n = %d", tc.n)
+	}
+
+	// only the first n segments need to exist
+	p, err = path.FromSegments("/ipfs/", a.Cid().String(), "child", "missing")
+	require.NoError(t, err)
+	rCid, _, remainder, err := r.ResolveN(ctx, p, 1)
+	require.NoError(t, err)
+	assert.Equal(t, b.Cid(), rCid)
+	assert.Equal(t, []string{"missing"}, remainder)
+	_, _, _, err = r.ResolveN(ctx, p, 2)
+	assert.ErrorAs(t, err, &resolver.ErrNoLink{})
+
+	_, _, _, err = r.ResolveN(ctx, p, -1)
+	assert.Error(t, err)
+}
+
+func TestResolveExistingPrefix(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	a := randNode()
+	b := randNode()
+	c := randNode()
+	require.NoError(t, b.AddNodeLink("grandchild", c))
+	require.NoError(t, a.AddNodeLink("child", b))
+	for _, n := range []*merkledag.ProtoNode{a, b, c} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	cases := []struct {
+		segments  []string
+		expected  cid.Cid
+		remainder []string
+	}{
+		{nil, a.Cid(), []string{}},
+		{[]string{"child", "grandchild"}, c.Cid(), []string{}},
+		{[]string{"child", "grandchild", "x", "y"}, c.Cid(), []string{"x", "y"}},
+		{[]string{"child", "x", "y"}, b.Cid(), []string{"x", "y"}},
+		{[]string{"x"}, a.Cid(), []string{"x"}},
+	}
+	for _, tc := range cases {
+		p, err := path.FromSegments("/ipfs/", append([]string{a.Cid().String()}, tc.segments...)...)
+		require.NoError(t, err)
+		rCid, remainder, err := r.ResolveExistingPrefix(ctx, p)
+		require.NoError(t, err, p)
+		assert.Equal(t, tc.expected, rCid, p)
+		assert.Equal(t, tc.remainder, remainder, p)
+	}
+
+	// missing blocks are not missing segments
+	missing := randNode()
+	p, err := path.FromSegments("/ipfs/", missing.Cid().String(), "x")
+	require.NoError(t, err)
+	_, _, err = r.ResolveExistingPrefix(ctx, p)
+	assert.Error(t, err)
+
+	// and neither are shards of a sharded directory failing to load
+	bsrv, root, entry := newFailingShard(t)
+	r = resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv))
+	p, err = path.FromSegments("/ipfs/", root.String(), entry, "x")
+	require.NoError(t, err)
+	_, remainder, err := r.ResolveExistingPrefix(ctx, p)
+	assert.ErrorAs(t, err, &resolver.ErrFetchFailed{})
+	assert.ErrorIs(t, err, errTransport)
+	assert.Nil(t, remainder)
+}
+
+func TestResolveWithOnCodec(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	leaf := randNode()
+	dir := randNode()
+	require.NoError(t, dir.AddNodeLink("leaf", leaf))
+	for _, n := range []*merkledag.ProtoNode{leaf, dir} {
+		require.NoError(t, bsrv.AddBlock(ctx, n))
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	err := dagjson.Decode(nb, strings.NewReader(`{"foo": {"dir": {"/": "`+dir.Cid().String()+`"}}}`))
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	root, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), root)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	type hop struct {
+		c     cid.Cid
+		codec uint64
+	}
+	var hops []hop
+	r := resolver.NewBasicResolver(newUnixFSFetcherFactory(bsrv), resolver.WithOnCodec(func(c cid.Cid, codec uint64) {
+		hops = append(hops, hop{c, codec})
+	}))
+	p := path.FromString(root.String() + "/foo/dir/leaf")
+
+	_, lnk, err := r.ResolvePath(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, cidlink.Link{Cid: leaf.Cid()}, lnk)
+	assert.Equal(t, []hop{
+		{root, cid.DagCBOR},
+		{dir.Cid(), cid.DagProtobuf},
+		{leaf.Cid(), cid.DagProtobuf},
+	}, hops)
+
+	// the last block is not fetched by ResolveToLastNode
+	hops = nil
+	_, _, err = r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, []hop{
+		{root, cid.DagCBOR},
+		{dir.Cid(), cid.DagProtobuf},
+	}, hops)
+}
@@ -0,0 +1,58 @@
+// Package resolvertest provides test doubles for the resolver package.
+package resolvertest
+
+import (
+	"context"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+)
+
+// StubResolver resolves a fixed set of paths to preconfigured cids, without
+// fetching anything. Its ResolveToLastNode method has the signature of
+// resolver.Resolver.ResolveToLastNode, so it can stand in for a Resolver
+// behind an interface in tests.
+type StubResolver struct {
+	paths map[string]cid.Cid
+}
+
+// NewStubResolver returns a StubResolver resolving each of the given paths to
+// the cid it maps to. Paths are compared once normalized, so /ipfs/<cid>/a/
+// and <cid>/a are the same path.
+func NewStubResolver(paths map[string]cid.Cid) *StubResolver {
+	r := &StubResolver{paths: make(map[string]cid.Cid, len(paths))}
+	for p, c := range paths {
+		r.paths[key(path.FromString(p))] = c
+	}
+	return r
+}
+
+// ResolveToLastNode returns the cid configured for fpath, with an empty
+// remainder. Unconfigured paths fail with a resolver.ErrNoLink for their last
+// segment.
+func (r *StubResolver) ResolveToLastNode(ctx context.Context, fpath path.Path) (cid.Cid, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+	if err := fpath.IsValid(); err != nil {
+		return cid.Cid{}, nil, err
+	}
+
+	c, ok := r.paths[key(fpath)]
+	if !ok {
+		segments := fpath.Segments()
+		return cid.Cid{}, nil, resolver.ErrNoLink{Name: segments[len(segments)-1]}
+	}
+	return c, []string{}, nil
+}
+
+// key returns the normalized form of p, or p itself if it cannot be
+// normalized.
+func key(p path.Path) string {
+	if normalized, err := p.Normalize(); err == nil {
+		p = normalized
+	}
+	return "/" + strings.Join(p.Segments(), "/")
+}
@@ -0,0 +1,54 @@
+package resolvertest_test
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	"github.com/ipfs/go-path/resolver/resolvertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lastNodeResolver is the interface downstream code would depend on.
+type lastNodeResolver interface {
+	ResolveToLastNode(context.Context, path.Path) (cid.Cid, []string, error)
+}
+
+var (
+	_ lastNodeResolver = (*resolver.Resolver)(nil)
+	_ lastNodeResolver = (*resolvertest.StubResolver)(nil)
+)
+
+func TestStubResolver(t *testing.T) {
+	ctx := context.Background()
+	root, err := cid.Decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	require.NoError(t, err)
+	c, err := cid.Decode("bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	require.NoError(t, err)
+
+	var r lastNodeResolver = resolvertest.NewStubResolver(map[string]cid.Cid{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b": c,
+	})
+
+	for _, p := range []string{
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b",
+		"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b/",
+		"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/./b",
+	} {
+		resolved, remainder, err := r.ResolveToLastNode(ctx, path.FromString(p))
+		require.NoError(t, err, p)
+		assert.Equal(t, c, resolved, p)
+		assert.Empty(t, remainder, p)
+	}
+
+	_, _, err = r.ResolveToLastNode(ctx, path.FromString("/ipfs/"+root.String()+"/a/c"))
+	var noLink resolver.ErrNoLink
+	require.ErrorAs(t, err, &noLink)
+	assert.Equal(t, "c", noLink.Name)
+
+	_, _, err = r.ResolveToLastNode(ctx, path.FromString("/ipfs/foo"))
+	assert.ErrorIs(t, err, path.ErrBadPath)
+}